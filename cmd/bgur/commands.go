@@ -0,0 +1,668 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/m1cr0man/bgur/pkg/bgur"
+)
+
+var commands = make(map[string]*command)
+
+func init() {
+	for _, cmd := range []*command{
+		{
+			name:   "set",
+			help:   "Change the background if it is due, then exit. Run this from cron",
+			folder: true,
+			flags:  forceFlag,
+			run:    runSet,
+		},
+		{
+			name:   "daemon",
+			help:   "Keep running, changing the background every change-interval.\nStop it with Ctrl+C or SIGTERM",
+			folder: true,
+			flags:  forceFlag,
+			run:    runDaemon,
+		},
+		{
+			name:   "next",
+			help:   "Change to the next background now",
+			folder: true,
+			flags:  monitorFlag,
+			run:    controlCommand(bgur.CommandNext, 0),
+		},
+		{
+			name:   "prev",
+			help:   "Go back to the previous background",
+			folder: true,
+			flags:  monitorFlag,
+			run:    controlCommand(bgur.CommandPrevious, 0),
+		},
+		{
+			name:   "pin",
+			args:   "DURATION",
+			help:   "Keep the current background for DURATION, such as 3h or 2h30m",
+			folder: true,
+			flags:  monitorFlag,
+			run:    controlCommand(bgur.CommandPin, 1),
+		},
+		{
+			name:   "show",
+			args:   "ID",
+			help:   "Show an image from the folder or the history",
+			folder: true,
+			flags:  monitorFlag,
+			run:    controlCommand(bgur.CommandShow, 1),
+		},
+		{
+			name:   "ban",
+			args:   "[current|ID|list]",
+			help:   "Never show the current or given image again, or list the banned images",
+			folder: true,
+			flags:  monitorFlag,
+			run:    controlCommand(bgur.CommandBan, -1),
+		},
+		{
+			name:   "unban",
+			args:   "ID",
+			help:   "Allow a banned image to be shown again",
+			folder: true,
+			run:    controlCommand(bgur.CommandUnban, 1),
+		},
+		{
+			name:   "status",
+			help:   "Show the current background and when it will change",
+			folder: true,
+			flags:  monitorFlag,
+			run:    controlCommand(bgur.CommandStatus, 0),
+		},
+		{
+			name:   "refresh",
+			help:   "Reload the list of images from Imgur now",
+			folder: true,
+			run:    controlCommand(bgur.CommandRefresh, 0),
+		},
+		{
+			name:   "history",
+			help:   "List past backgrounds",
+			folder: true,
+			flags:  historyFlags,
+			run:    runHistory,
+		},
+		{
+			name:   "history search",
+			args:   "QUERY",
+			help:   "Search past backgrounds by id, link, title, folder or monitor",
+			folder: true,
+			flags:  historyFlags,
+			run:    runHistory,
+		},
+		{
+			name:   "history apply",
+			args:   "ID",
+			help:   "Show a past background again",
+			folder: true,
+			flags:  monitorFlag,
+			run:    controlCommand(bgur.CommandShow, 1),
+		},
+		{
+			name:   "cache stats",
+			help:   "Show the size of the image cache",
+			config: true,
+			run:    runCache,
+		},
+		{
+			name:   "cache prune",
+			help:   "Remove the least recently shown images until the cache is within budget",
+			folder: true,
+			run:    runCache,
+		},
+		{
+			name:   "sync push",
+			help:   "Upload the state to Imgur, replacing the state of other computers",
+			folder: true,
+			run:    runSync,
+		},
+		{
+			name:   "sync pull",
+			help:   "Replace the state with the state on Imgur",
+			folder: true,
+			run:    runSync,
+		},
+		{
+			name:   "upload",
+			args:   "ALBUM",
+			help:   "Upload the images in a directory to an album, creating it if needed",
+			folder: true,
+			flags: func(inv *invocation) {
+				inv.flags.String("dir", ".", "Directory of images to upload")
+			},
+			run: runUpload,
+		},
+		{
+			name:   "favourites export",
+			help:   "Save the favourites of the folder owner as JSON to " + bgur.FavouritesFile,
+			folder: true,
+			run:    runFavourites,
+		},
+		{
+			name:   "folder add-album",
+			args:   "ALBUM_ID",
+			help:   "Add an album to the backgrounds folder",
+			folder: true,
+			run:    runAddAlbum,
+		},
+		{
+			name:   "auth",
+			help:   "Authorise bgur with Imgur",
+			config: true,
+			flags:  authFlags,
+			run:    runAuth,
+		},
+		{
+			name:   "auth login",
+			args:   "ACCOUNT",
+			help:   "Authorise a new account, named however you like",
+			config: true,
+			flags:  headlessFlag,
+			run:    runAuthLogin,
+		},
+		{
+			name:   "auth logout",
+			args:   "[ACCOUNT]",
+			help:   "Forget the token of the account, or the current account",
+			config: true,
+			run:    runAuthLogout,
+		},
+		{
+			name: "auth list",
+			help: "List the accounts which have been authorised",
+			run:  runAuthList,
+		},
+		{
+			name: "auth switch",
+			args: "ACCOUNT",
+			help: "Use the account when -account isn't given",
+			run:  runAuthSwitch,
+		},
+		{
+			name:   "config show",
+			help:   "Print the settings in use after applying the profile and flags",
+			folder: true,
+			run:    runConfigShow,
+		},
+		{
+			name: "help",
+			args: "[COMMAND]",
+			help: "Show the commands, or the flags of a command",
+			run:  runHelp,
+		},
+	} {
+		commands[cmd.name] = cmd
+	}
+	commands["previous"] = commands["prev"]
+}
+
+func forceFlag(inv *invocation) {
+	inv.flags.Bool("force-change", false, "Force a background change now. Overrides expiry")
+}
+
+func monitorFlag(inv *invocation) {
+	inv.flags.String("monitor", "", "Name of the monitor to use. Defaults to all monitors")
+}
+
+func historyFlags(inv *invocation) {
+	inv.flags.Int("n", 20, "Number of entries to show, or 0 for all")
+}
+
+// flagValue returns the value of a flag added by the command
+func (inv *invocation) flagValue(name string) flag.Getter {
+	return inv.flags.Lookup(name).Value.(flag.Getter)
+}
+
+// checkArgs returns a usage error unless count arguments were given
+func (inv *invocation) checkArgs(count int) error {
+	if len(inv.args) != count {
+		return usagef("expected %d arguments, got %d", count, len(inv.args))
+	}
+	return nil
+}
+
+func runSet(inv *invocation) (err error) {
+	if err = inv.checkArgs(0); err != nil {
+		return
+	}
+	if err = inv.loadApp(); err != nil {
+		return
+	}
+
+	if inv.flagValue("force-change").Get().(bool) {
+		inv.app.ExpireBackground()
+	}
+
+	fmt.Println("Picking an image and setting the background")
+	if err = inv.app.ChangeBackground(inv.expiry()); err != nil {
+		return
+	}
+
+	// Wait for downloads to finish, the next run may be offline
+	<-inv.app.StartPrefetch()
+	return
+}
+
+func runDaemon(inv *invocation) (err error) {
+	if err = inv.checkArgs(0); err != nil {
+		return
+	}
+	if err = inv.loadApp(); err != nil {
+		return
+	}
+
+	if inv.flagValue("force-change").Get().(bool) {
+		inv.app.ExpireBackground()
+	}
+
+	// Only refresh the cache once when requested, then keep to the usual schedule
+	inv.app.CacheTime = bgur.DefaultCacheTime
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	fmt.Println("Running as a daemon. Changing background every", inv.expiry())
+	return inv.app.RunDaemon(inv.expiry(), stop)
+}
+
+// controlCommand runs a command through the daemon if it is running, or directly
+// if not. args is the number of arguments expected, or -1 for at most one.
+func controlCommand(name string, args int) func(inv *invocation) error {
+	return func(inv *invocation) (err error) {
+		if args < 0 && len(inv.args) > 1 {
+			return usagef("expected at most 1 argument, got %d", len(inv.args))
+		} else if args >= 0 {
+			if err = inv.checkArgs(args); err != nil {
+				return
+			}
+		}
+
+		request := bgur.ControlRequest{Command: name}
+		if monitor := inv.flags.Lookup("monitor"); monitor != nil {
+			request.Monitor = monitor.Value.String()
+		}
+
+		switch name {
+		case bgur.CommandPin:
+			request.Duration = inv.args[0]
+		case bgur.CommandShow, bgur.CommandUnban:
+			request.ImageId = inv.args[0]
+		case bgur.CommandBan:
+			if len(inv.args) > 0 && inv.args[0] == "list" {
+				request.Command = bgur.CommandBans
+			} else if len(inv.args) > 0 && inv.args[0] != "current" {
+				request.ImageId = inv.args[0]
+			}
+		}
+
+		response, err := inv.sendControl(request)
+		if err != nil {
+			return
+		}
+
+		if request.Command == bgur.CommandBans {
+			for _, imageId := range response.Banned {
+				fmt.Println(imageId)
+			}
+			return
+		}
+
+		for _, status := range response.Status.Monitors {
+			if status.Monitor != "" {
+				fmt.Println("Monitor:", status.Monitor)
+			}
+			fmt.Println("Background:", status.Image.Link, status.Image.Title)
+			fmt.Printf("Position: %d/%d in %s\n", status.Position+1, status.Total, status.Folder)
+			fmt.Println("Changed:", status.DateChanged)
+			fmt.Println("Next change:", status.NextChange)
+			if status.PinnedUntil != "" {
+				fmt.Println("Pinned until:", status.PinnedUntil)
+			}
+		}
+		return
+	}
+}
+
+// sendControl sends the request to the daemon, or handles it here when the daemon isn't running
+func (inv *invocation) sendControl(request bgur.ControlRequest) (response bgur.ControlResponse, err error) {
+	account, err := inv.account()
+	if err != nil {
+		return
+	}
+	if bgur.DaemonRunning(inv.configDir, account) {
+		return bgur.SendControl(inv.configDir, account, request)
+	}
+
+	if err = inv.loadApp(); err != nil {
+		return
+	}
+
+	response = inv.app.HandleControl(request, inv.expiry())
+	if request.Command != bgur.CommandStatus && request.Command != bgur.CommandBans {
+		// Wait for downloads to finish, the next run may be offline
+		<-inv.app.StartPrefetch()
+	}
+
+	if !response.Success {
+		err = errors.New(response.Error)
+	}
+	return
+}
+
+func runHistory(inv *invocation) (err error) {
+	search := inv.command.name == "history search"
+	if search {
+		err = inv.checkArgs(1)
+	} else {
+		err = inv.checkArgs(0)
+	}
+	if err != nil {
+		return
+	}
+	count := inv.flagValue("n").Get().(int)
+	if count < 0 {
+		return usagef("-n can't be negative")
+	}
+
+	account, err := inv.account()
+	if err != nil {
+		return
+	}
+	entries, err := bgur.ReadHistory(inv.configDir, account)
+	if err != nil {
+		return
+	}
+
+	var matches []bgur.HistoryEntry
+	for _, entry := range entries {
+		if !search || entry.Matches(inv.args[0]) {
+			matches = append(matches, entry)
+		}
+	}
+	if count > 0 && len(matches) > count {
+		matches = matches[len(matches)-count:]
+	}
+
+	for _, entry := range matches {
+		fmt.Printf("%s  %s  %s  %s  %s %s\n", entry.Time, entry.Machine, entry.Monitor,
+			entry.ImageId, entry.Link, entry.Title)
+	}
+	return
+}
+
+func runCache(inv *invocation) (err error) {
+	if err = inv.checkArgs(0); err != nil {
+		return
+	}
+	budget := inv.config.Cache
+
+	// Only pruning needs Imgur, to know which images are in use
+	if inv.command.name == "cache prune" {
+		if !budget.Limited() {
+			return fmt.Errorf("no cache budget is set in %s", bgur.ConfigFile(inv.configDir))
+		}
+		if err = inv.loadApp(); err != nil {
+			return
+		}
+
+		removed, err := inv.app.PruneCache()
+		for _, file := range removed {
+			fmt.Println("Removed", file)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	stats, err := bgur.GetCacheStats(inv.cacheDir)
+	if err != nil {
+		return
+	}
+
+	fmt.Println("Cache directory:", inv.cacheDir)
+	fmt.Println("Images:", stats.Files)
+	fmt.Println("Size:", bgur.FormatBytes(stats.Bytes))
+	if stats.Files > 0 {
+		fmt.Println("Least recently shown:", stats.Oldest.Format(time.RFC1123))
+	}
+	if budget.MaxFiles > 0 {
+		fmt.Println("Maximum images:", budget.MaxFiles)
+	}
+	if budget.MaxBytes > 0 {
+		fmt.Println("Maximum size:", bgur.FormatBytes(budget.MaxBytes))
+	}
+	return
+}
+
+func runSync(inv *invocation) (err error) {
+	if err = inv.checkArgs(0); err != nil {
+		return
+	}
+	if err = inv.newApp(true); err != nil {
+		return
+	}
+	if err = inv.selectFolder(); err != nil {
+		return
+	}
+	if err = inv.app.LoadState(); err != nil {
+		fmt.Println("Failed to load state: ", err)
+	}
+
+	if inv.command.name == "sync push" {
+		err = inv.app.PushState()
+	} else {
+		err = inv.app.PullState()
+	}
+	if err == nil {
+		fmt.Println("State synced")
+	}
+	return
+}
+
+func runUpload(inv *invocation) (err error) {
+	if err = inv.checkArgs(1); err != nil {
+		return
+	}
+	if err = inv.newApp(true); err != nil {
+		return
+	}
+	return inv.app.UploadAllImages(inv.flagValue("dir").String(), inv.args[0])
+}
+
+func runFavourites(inv *invocation) (err error) {
+	if err = inv.checkArgs(0); err != nil {
+		return
+	}
+	if err = inv.newApp(true); err != nil {
+		return
+	}
+	if err = inv.app.DumpFavourites(inv.options.folderOwner); err != nil {
+		return
+	}
+	fmt.Println("Saved favourites to", bgur.FavouritesFile)
+	return
+}
+
+func runAddAlbum(inv *invocation) (err error) {
+	if err = inv.checkArgs(1); err != nil {
+		return
+	}
+	if err = inv.newApp(true); err != nil {
+		return
+	}
+	if err = inv.selectFolder(); err != nil {
+		return
+	}
+	if err = inv.app.AddAlbumToFolder(inv.args[0]); err != nil {
+		return
+	}
+	fmt.Println("Album added")
+	return
+}
+
+func headlessFlag(inv *invocation) {
+	inv.flags.Bool("headless", false,
+		"Authorise from a browser on another machine by pasting the address it redirects to")
+}
+
+func authFlags(inv *invocation) {
+	headlessFlag(inv)
+	inv.options.registerAccount(inv.flags)
+}
+
+func runAuth(inv *invocation) (err error) {
+	if err = inv.checkArgs(0); err != nil {
+		return
+	}
+	if err = inv.newApp(true); err != nil {
+		return
+	}
+	fmt.Println("Authorised", inv.app.Account, "as", inv.app.AuthorisedUsername())
+	return
+}
+
+func runAuthLogin(inv *invocation) (err error) {
+	if err = inv.checkArgs(1); err != nil {
+		return
+	}
+	inv.options.account = inv.args[0]
+	if err = inv.newApp(true); err != nil {
+		return
+	}
+	fmt.Println("Logged in to", inv.app.Account, "as", inv.app.AuthorisedUsername())
+	return
+}
+
+func runAuthLogout(inv *invocation) (err error) {
+	if len(inv.args) > 1 {
+		return usagef("expected at most 1 argument, got %d", len(inv.args))
+	} else if len(inv.args) == 1 {
+		inv.options.account = inv.args[0]
+	}
+
+	// No need to authorise to forget the token
+	app := bgur.NewApp(inv.configDir, inv.cacheDir, bgur.DefaultCacheTime, false)
+	app.TokenStore = inv.config.TokenStore
+	if app.Account, err = inv.account(); err != nil {
+		return
+	}
+	if err = app.Logout(); err != nil {
+		return
+	}
+	fmt.Println("Logged out of", app.Account)
+	return
+}
+
+func runAuthList(inv *invocation) (err error) {
+	if err = inv.checkArgs(0); err != nil {
+		return
+	}
+
+	accounts, err := bgur.LoadAccounts(inv.configDir)
+	if err != nil {
+		return
+	}
+	if len(accounts.Accounts) == 0 {
+		fmt.Println("No accounts yet. Add one with bgur auth login ACCOUNT")
+		return
+	}
+
+	current := accounts.Selected("")
+	for _, account := range accounts.Accounts {
+		marker := " "
+		if account.Name == current {
+			marker = "*"
+		}
+		fmt.Printf("%s %s\t%s\n", marker, account.Name, account.Username)
+	}
+	return
+}
+
+func runAuthSwitch(inv *invocation) (err error) {
+	if err = inv.checkArgs(1); err != nil {
+		return
+	}
+
+	accounts, err := bgur.LoadAccounts(inv.configDir)
+	if err != nil {
+		return
+	}
+	if _, found := accounts.Find(inv.args[0]); !found {
+		return fmt.Errorf("no account called %s. Log in with bgur auth login %s", inv.args[0], inv.args[0])
+	}
+
+	accounts.Current = inv.args[0]
+	if err = accounts.Save(inv.configDir); err != nil {
+		return
+	}
+	fmt.Println("Using", accounts.Current)
+	return
+}
+
+func runConfigShow(inv *invocation) (err error) {
+	if err = inv.checkArgs(0); err != nil {
+		return
+	}
+
+	fmt.Println("Config file:", bgur.ConfigFile(inv.configDir))
+	if inv.options.profile != "" {
+		fmt.Println("Profile:", inv.options.profile)
+	}
+
+	fmt.Println("Flags:")
+	inv.flags.VisitAll(func(f *flag.Flag) {
+		if f.Name != "profile" {
+			fmt.Printf("  -%s=%s\n", f.Name, f.Value)
+		}
+	})
+
+	// The profile has already been applied
+	config := inv.config
+	config.Profiles = nil
+	if config.Imgur.ClientSecret != "" {
+		config.Imgur.ClientSecret = "hidden"
+	}
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return
+	}
+	fmt.Println("Config:")
+	fmt.Println(string(data))
+	return
+}
+
+func runHelp(inv *invocation) error {
+	if len(inv.args) == 0 {
+		printCommands()
+		return nil
+	}
+
+	cmd, _, err := findCommand(inv.args)
+	if err != nil {
+		return err
+	}
+
+	help := &invocation{command: cmd, flags: flag.NewFlagSet(cmd.name, flag.ContinueOnError)}
+	if cmd.folder {
+		help.options.register(help.flags)
+	}
+	if cmd.flags != nil {
+		cmd.flags(help)
+	}
+	help.usage()
+	return nil
+}
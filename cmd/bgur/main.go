@@ -4,181 +4,437 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/kirsle/configdir"
 	"github.com/m1cr0man/bgur/pkg/bgur"
-	"github.com/reujab/wallpaper"
 )
 
-func main() {
-	var err error
+// Exit codes
+const (
+	exitFailure = 1
+	// The command line was invalid
+	exitUsage = 2
+)
+
+// Used when no command is given, so that old cron lines keep working
+const defaultCommand = "set"
+
+// usageError is returned when the command line is invalid. The usage of the command is printed with it
+type usageError struct {
+	message string
+}
+
+func (e usageError) Error() string {
+	return e.message
+}
+
+func usagef(format string, args ...interface{}) error {
+	return usageError{fmt.Sprintf(format, args...)}
+}
+
+type command struct {
+	name string
+	// Arguments which come after the flags, shown in the usage
+	args string
+	help string
+	// Set if the command works with the folder, so needs the folder and filter flags
+	folder bool
+	// Set if the command needs the config file without the folder. Implied by folder
+	config bool
+	// Adds the flags of this command only
+	flags func(inv *invocation)
+	run   func(inv *invocation) error
+}
+
+// options are the flags shared by every command which works with the folder
+type options struct {
+	profile        string
+	folderName     string
+	folderOwner    string
+	expiry         int
+	refreshCache   bool
+	minRatio       int
+	maxRatio       int
+	resolution     string
+	fit            string
+	acceptAnyRatio bool
+	seed           int64
+	sync           bool
+	account        string
+}
+
+func (o *options) registerAccount(flags *flag.FlagSet) {
+	flags.StringVar(&o.account, "account", "",
+		"Account to use, from bgur auth list. Defaults to the one chosen with bgur auth switch")
+}
 
-	folderName := flag.String("folder-name", "desktop backgrounds",
+func (o *options) register(flags *flag.FlagSet) {
+	flags.StringVar(&o.profile, "profile", "",
+		"Profile in the config file to take settings from. Flags override the profile")
+	flags.StringVar(&o.folderName, "folder-name", "desktop backgrounds",
 		"Name of the folder to pull desktop backgrounds from")
-	folderOwner := flag.String("folder-owner", "",
+	flags.StringVar(&o.folderOwner, "folder-owner", "",
 		"Username who owns the backgrounds folder. Defaults to you")
-	expiry := flag.Int("change-interval", 60*12,
+	flags.IntVar(&o.expiry, "change-interval", 60*12,
 		"Minutes between background changes. Default is 12 hours")
-	force := flag.Bool("force-change", false,
-		"Force a background change now. Overrides expiry")
-	refreshCache := flag.Bool("refresh-cache", false,
+	flags.BoolVar(&o.refreshCache, "refresh-cache", false,
 		"Refresh list of images from the folder on Imgur")
-	minRatio := flag.Int("min-ratio", 0,
+	flags.IntVar(&o.minRatio, "min-ratio", 0,
 		"Minimum ratio of width:height, in percent. For example 160 which is 16:10")
-	maxRatio := flag.Int("max-ratio", 0,
+	flags.IntVar(&o.maxRatio, "max-ratio", 0,
 		"Maximum ratio of width:height, in percent. Use this for vertical screens, overrides minRatio")
-	seed := flag.Int64("seed", time.Now().Unix(),
+	flags.StringVar(&o.resolution, "resolution", "",
+		"Resize images to this resolution before setting them, for example 2560x1440")
+	flags.StringVar(&o.fit, "fit", bgur.FitCrop,
+		"How to fit images to -resolution. crop to fill the screen, fit to show the whole image, "+
+			"blur to show the whole image over a blurred copy of itself")
+	flags.BoolVar(&o.acceptAnyRatio, "accept-any-ratio", false,
+		"Use images outside of min-ratio and max-ratio, fitting them with -fit fit or -fit blur")
+	flags.Int64Var(&o.seed, "seed", time.Now().Unix(),
 		"Seed to use for shuffling the folder. Set to 0 to skip shuffling")
-	sync := flag.Bool("sync", false,
+	flags.BoolVar(&o.sync, "sync", false,
 		"Sync state to Imgur so that the same backgrounds appear on other computers")
-	favourites := flag.Bool("favourites", false,
-		"List favourites")
-	addAlbum := flag.String("add-album", "",
-		"Album ID to add to your backgrounds folder")
-	albumName := flag.String("album-name", "",
-		"Album to create and upload images in current folder to")
-	flag.Parse()
-
-	configDir := configdir.LocalConfig("bgur")
-	err = configdir.MakePath(configDir) // Ensure it exists.
-	if err != nil {
-		fmt.Println("Failed to get config dir: ", err)
-		os.Exit(1)
-		return
+	o.registerAccount(flags)
+}
+
+// invocation holds everything a command needs to run
+type invocation struct {
+	command   *command
+	flags     *flag.FlagSet
+	options   options
+	args      []string
+	configDir string
+	cacheDir  string
+	config    bgur.Config
+	app       *bgur.App
+}
+
+func (inv *invocation) expiry() time.Duration {
+	return time.Minute * time.Duration(inv.options.expiry)
+}
+
+func (inv *invocation) usage() {
+	cmd := inv.command
+	fmt.Fprintf(os.Stderr, "Usage: bgur %s\n\n%s\n", strings.TrimSpace(cmd.name+" [flags] "+cmd.args), cmd.help)
+	hasFlags := false
+	inv.flags.VisitAll(func(*flag.Flag) {
+		hasFlags = true
+	})
+	if hasFlags {
+		fmt.Fprintln(os.Stderr, "\nFlags:")
+		inv.flags.PrintDefaults()
 	}
+}
 
-	cacheDir := configdir.LocalCache("bgur")
-	err = configdir.MakePath(cacheDir) // Ensure it exists.
-	if err != nil {
-		fmt.Println("Failed to get cache dir: ", err)
-		os.Exit(1)
+// prepare creates the config and cache dirs, loads the config and applies the profile
+func (inv *invocation) prepare() (err error) {
+	inv.configDir = configdir.LocalConfig("bgur")
+	if err = configdir.MakePath(inv.configDir); err != nil {
+		return fmt.Errorf("failed to get config dir: %s", err)
+	}
+
+	inv.cacheDir = configdir.LocalCache("bgur")
+	if err = configdir.MakePath(inv.cacheDir); err != nil {
+		return fmt.Errorf("failed to get cache dir: %s", err)
+	}
+
+	if !inv.command.folder && !inv.command.config {
 		return
 	}
 
-	shutdownChan := make(chan error)
+	if inv.config, err = bgur.LoadConfig(inv.configDir); err != nil {
+		return fmt.Errorf("failed to load config: %s", err)
+	}
+
+	if inv.options.profile != "" {
+		profile, err := inv.config.UseProfile(inv.options.profile)
+		if err == nil {
+			err = applyProfile(inv.flags, profile)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to load profile: %s", err)
+		}
+	}
+	return
+}
+
+// applyProfile sets the flags which weren't given on the command line from the profile
+func applyProfile(flags *flag.FlagSet, profile bgur.Profile) error {
+	values := make(map[string]string)
+	if profile.FolderName != "" {
+		values["folder-name"] = profile.FolderName
+	}
+	if profile.FolderOwner != "" {
+		values["folder-owner"] = profile.FolderOwner
+	}
+	if profile.ChangeInterval > 0 {
+		values["change-interval"] = strconv.Itoa(profile.ChangeInterval)
+	}
+	if profile.MinRatio > 0 {
+		values["min-ratio"] = strconv.Itoa(profile.MinRatio)
+	}
+	if profile.MaxRatio > 0 {
+		values["max-ratio"] = strconv.Itoa(profile.MaxRatio)
+	}
+	if profile.Resolution != "" {
+		values["resolution"] = profile.Resolution
+	}
+	if profile.Fit != "" {
+		values["fit"] = profile.Fit
+	}
+	if profile.AcceptAnyRatio {
+		values["accept-any-ratio"] = "true"
+	}
+	if profile.Sync {
+		values["sync"] = "true"
+	}
+	if profile.Seed != nil {
+		values["seed"] = strconv.FormatInt(*profile.Seed, 10)
+	}
+	if profile.Account != "" {
+		values["account"] = profile.Account
+	}
+
+	explicit := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	for name, value := range values {
+		if explicit[name] {
+			continue
+		}
+		if err := flags.Set(name, value); err != nil {
+			return fmt.Errorf("invalid %s %s: %s", name, value, err)
+		}
+	}
+	return nil
+}
+
+// newApp authorises with Imgur. If needImgur is false, bgur carries on offline
+// when authorisation fails.
+func (inv *invocation) newApp(needImgur bool) (err error) {
+	o := &inv.options
 
 	// Set cache time to 7 days, or refresh now if specified
-	cacheTime := time.Hour * 24 * 7
-	if *refreshCache {
+	cacheTime := bgur.DefaultCacheTime
+	if o.refreshCache {
 		cacheTime = 0
 	}
 
-	app := bgur.NewApp(configDir, cacheDir, cacheTime, *sync)
-	go app.RunServer(shutdownChan)
+	app := bgur.NewApp(inv.configDir, inv.cacheDir, cacheTime, o.sync)
+	app.CacheBudget = inv.config.Cache
+	app.Prefetch = inv.config.Prefetch
+	app.PrefetchConcurrency = inv.config.PrefetchConcurrency
+	app.ShareCurrent = inv.config.ShareCurrent
+	app.TokenStore = inv.config.TokenStore
+	if err = app.SetImgurClient(inv.config.Imgur); err != nil {
+		return
+	}
+	if app.Account, err = inv.account(); err != nil {
+		return
+	}
+	app.Schedule = inv.config.Schedule
+	app.Location = inv.config.Location
+
+	// Monitors from the config file have their own filters
+	app.Monitors = inv.config.Monitors
+	if len(app.Monitors) == 0 {
+		monitor := bgur.Monitor{MinRatio: o.minRatio, MaxRatio: o.maxRatio, Fit: o.fit, AcceptAnyRatio: o.acceptAnyRatio}
+		if o.resolution != "" {
+			if _, err = fmt.Sscanf(o.resolution, "%dx%d", &monitor.Width, &monitor.Height); err != nil {
+				return usagef("invalid resolution %s: %s", o.resolution, err)
+			}
+		}
+		app.Monitors = []bgur.Monitor{monitor}
+	}
+
+	inv.app = app
+
+	if inv.flags.Lookup("headless") != nil {
+		app.SetHeadless(inv.flagValue("headless").Get().(bool))
+	}
 
 	if err = app.Authorise(); err != nil {
+		if needImgur {
+			return fmt.Errorf("failed to authorise: %s", err)
+		}
+		// Backgrounds can be set from the cache
 		fmt.Println("Failed to authorise: ", err)
-		os.Exit(1)
-		return
+		app.SetOffline(err)
 	}
 
-	if *folderOwner == "" {
-		*folderOwner = app.AuthorisedUsername()
+	if o.folderOwner == "" {
+		o.folderOwner = app.AuthorisedUsername()
 	}
+	return
+}
 
-	if err = app.SelectFolder(*folderOwner, *folderName); err != nil {
-		fmt.Println("Failed to select folder: ", err)
-		os.Exit(1)
-		return
+// account returns the account chosen with -account, or the current account
+func (inv *invocation) account() (string, error) {
+	accounts, err := bgur.LoadAccounts(inv.configDir)
+	if err != nil {
+		return "", err
 	}
 
-	if *favourites {
-		err = app.DumpFavourites(*folderOwner)
-		if err != nil {
-			fmt.Println("Failed to dump favourites: ", err)
-			os.Exit(1)
-			return
-		}
-		os.Exit(0)
-		return
+	name := accounts.Selected(inv.options.account)
+	if err = bgur.ValidateAccountName(name); err != nil {
+		return "", usageError{err.Error()}
 	}
+	return name, nil
+}
 
-	if *addAlbum != "" {
-		err = app.AddAlbumToFolder(*addAlbum)
-		if err != nil {
-			fmt.Println("Failed to add album to favourites folder:", err)
-			os.Exit(1)
-			return
-		}
-		fmt.Println("Album added")
-		os.Exit(0)
-		return
+func (inv *invocation) selectFolder() (err error) {
+	o := inv.options
+	app := inv.app
+
+	// Folders from the config file are merged into one rotation
+	if len(inv.config.Folders) > 0 {
+		err = app.SelectFolders(o.folderOwner, inv.config.Folders)
+	} else {
+		err = app.SelectFolder(o.folderOwner, o.folderName)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to select folder: %s", err)
 	}
+	return
+}
 
-	if *albumName != "" {
-		err = app.UploadAllImages(".", *albumName)
-		if err != nil {
-			fmt.Println("Failed to upload images:", err)
-			os.Exit(1)
-			return
-		}
-		os.Exit(0)
+// loadApp prepares the App for changing backgrounds by loading the state and images
+func (inv *invocation) loadApp() (err error) {
+	if err = inv.newApp(false); err != nil {
+		return
+	}
+	if err = inv.selectFolder(); err != nil {
 		return
 	}
+	app := inv.app
 
 	if err = app.LoadState(); err != nil {
 		fmt.Println("Failed to load state: ", err)
 	}
-	err = nil
 
-	if *sync {
+	if inv.options.sync {
 		fmt.Println("Syncing state with imgur")
 
 		if err = app.SyncState(); err != nil {
 			fmt.Println("Failed to sync state: ", err)
 		}
-		err = nil
 	}
 
 	// After LoadState so that old seed is loaded, incase seed == -1
-	app.SetSeed(*seed)
+	app.SetSeed(inv.options.seed)
 
 	fmt.Println("Loading available images")
 	if err = app.LoadImages(); err != nil {
-		fmt.Println("Failed to load images:", err)
-		os.Exit(1)
-		return
+		return fmt.Errorf("failed to load images: %s", err)
 	}
 
 	fmt.Println("Loaded", app.CountImages(), "images")
-	fmt.Println("Picking an image and setting the background")
-	if *force {
-		*expiry = 0
+	return nil
+}
+
+// findCommand looks up the command named by the first one or two words of args.
+// The remaining args are returned.
+func findCommand(args []string) (*command, []string, error) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return commands[defaultCommand], args, nil
 	}
-	image, err := app.PickImage(time.Minute*time.Duration(*expiry), *minRatio, *maxRatio)
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
-		return
+
+	if len(args) > 1 {
+		if cmd, found := commands[args[0]+" "+args[1]]; found {
+			return cmd, args[2:], nil
+		}
+	}
+	if cmd, found := commands[args[0]]; found {
+		return cmd, args[1:], nil
 	}
-	fmt.Println("Using", image.Link, "as desktop background")
 
-	imagePath, err := app.DownloadImage(image)
-	if err != nil {
-		fmt.Println("Failed to download image: ", err)
+	// A group of commands such as cache
+	var group []string
+	for name := range commands {
+		if strings.HasPrefix(name, args[0]+" ") {
+			group = append(group, name)
+		}
+	}
+	if len(group) > 0 {
+		sort.Strings(group)
+		return nil, nil, usagef("%s needs a subcommand: %s", args[0], strings.Join(group, ", "))
+	}
+	return nil, nil, usagef("unknown command %s", args[0])
+}
+
+func printCommands() {
+	names := make([]string, 0, len(commands))
+	width := 0
+	for name, cmd := range commands {
+		// Skip aliases
+		if name != cmd.name {
+			continue
+		}
+		names = append(names, name)
+		if len(name) > width {
+			width = len(name)
+		}
 	}
+	sort.Strings(names)
 
-	err = wallpaper.SetFromFile(imagePath)
+	fmt.Fprintln(os.Stderr, "Usage: bgur COMMAND [flags] [args]")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	for _, name := range names {
+		help := strings.SplitN(commands[name].help, "\n", 2)[0]
+		fmt.Fprintf(os.Stderr, "  %-*s  %s\n", width, name, help)
+	}
+	fmt.Fprintln(os.Stderr, "\nRun bgur help COMMAND for the flags of a command. The default command is "+defaultCommand)
+}
+
+func run(args []string) int {
+	cmd, args, err := findCommand(args)
 	if err != nil {
-		fmt.Println("Failed to set desktop background: ", err)
+		fmt.Fprintln(os.Stderr, err)
+		printCommands()
+		return exitUsage
+	}
+
+	inv := &invocation{
+		command: cmd,
+		flags:   flag.NewFlagSet(cmd.name, flag.ContinueOnError),
+	}
+	inv.flags.Usage = inv.usage
+	if cmd.folder {
+		inv.options.register(inv.flags)
+	}
+	if cmd.flags != nil {
+		cmd.flags(inv)
 	}
 
-	// Save images after picking so that DateSeen is saved
-	if err = app.SaveImages(); err != nil {
-		fmt.Println("Failed to save cache of images: ", err, " This will slow down subsequent runs")
+	if err = inv.flags.Parse(args); err == flag.ErrHelp {
+		return 0
+	} else if err != nil {
+		return exitUsage
 	}
+	inv.args = inv.flags.Args()
 
-	if *sync {
-		fmt.Println("Saving state to imgur")
+	err = inv.prepare()
+	if err == nil {
+		err = cmd.run(inv)
 	}
-	if err = app.SaveState(); err != nil {
-		fmt.Println("Failed to save state: ", err)
-		os.Exit(1)
-		return
+
+	if _, ok := err.(usageError); ok {
+		fmt.Fprintln(os.Stderr, err)
+		inv.usage()
+		return exitUsage
+	} else if err != nil {
+		fmt.Println("Failed to "+cmd.name+": ", err)
+		return exitFailure
 	}
+	return 0
+}
 
-	// Wait until web app is killed
-	app.StopServer()
-	_ = <-shutdownChan
+func main() {
+	os.Exit(run(os.Args[1:]))
 }
@@ -18,6 +18,8 @@ func main() {
 		"Name of the folder to add new albums to")
 	source := flag.String("source", ".",
 		"Folder to begin uploading from")
+	headless := flag.Bool("headless", false,
+		"Authorise from a browser on another machine by pasting the address it redirects to")
 	flag.Parse()
 
 	configDir := configdir.LocalConfig("bgur")
@@ -36,11 +38,23 @@ func main() {
 		return
 	}
 
-	shutdownChan := make(chan error)
+	config, err := bgur.LoadConfig(configDir)
+	if err != nil {
+		fmt.Println("Failed to load config: ", err)
+		os.Exit(1)
+		return
+	}
+
 	cacheTime := time.Hour * 24 * 7
 
 	app := bgur.NewApp(configDir, cacheDir, cacheTime, false)
-	go app.RunServer(shutdownChan)
+	app.SetHeadless(*headless)
+	app.TokenStore = config.TokenStore
+	if err = app.SetImgurClient(config.Imgur); err != nil {
+		fmt.Println("Failed to set the Imgur app: ", err)
+		os.Exit(1)
+		return
+	}
 
 	if err = app.Authorise(); err != nil {
 		fmt.Println("Failed to authorise: ", err)
@@ -77,8 +91,4 @@ func main() {
 	}
 
 	fmt.Println("Finshed uploading images")
-
-	// Wait until web app is killed
-	app.StopServer()
-	_ = <-shutdownChan
 }
@@ -0,0 +1,168 @@
+package bgur
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+const AccountsFileName = "accounts.json"
+
+// DefaultAccount is used when no account is chosen. Its files keep the names
+// they had before bgur supported more than one account.
+const DefaultAccount = "default"
+
+// Account names end up in file names, so they are kept simple
+var accountNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Account is a named Imgur login
+type Account struct {
+	Name     string `json:"name"`
+	Username string `json:"username,omitempty"`
+}
+
+// Accounts lists the accounts which have been authorised
+type Accounts struct {
+	// Used when no account is given
+	Current  string    `json:"current,omitempty"`
+	Accounts []Account `json:"accounts"`
+}
+
+func AccountsFile(configDir string) string {
+	return filepath.Join(configDir, AccountsFileName)
+}
+
+func ValidateAccountName(name string) error {
+	if !accountNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid account name %q. Use letters, numbers, - and _", name)
+	}
+	return nil
+}
+
+// LoadAccounts reads the list of accounts. A missing file is not an error
+func LoadAccounts(configDir string) (accounts Accounts, err error) {
+	data, err := ioutil.ReadFile(AccountsFile(configDir))
+	if os.IsNotExist(err) {
+		return accounts, nil
+	} else if err != nil {
+		return
+	}
+
+	if err = json.Unmarshal(data, &accounts); err != nil {
+		return accounts, fmt.Errorf("failed to parse %s: %s", AccountsFile(configDir), err)
+	}
+	return
+}
+
+func (a Accounts) Save(configDir string) error {
+	data, _ := json.MarshalIndent(a, "", "  ")
+	return writeFileAtomic(AccountsFile(configDir), data)
+}
+
+func (a Accounts) Find(name string) (Account, bool) {
+	for _, account := range a.Accounts {
+		if account.Name == name {
+			return account, true
+		}
+	}
+	return Account{}, false
+}
+
+// Add adds the account, or updates it if it is already listed
+func (a *Accounts) Add(account Account) {
+	for i := range a.Accounts {
+		if a.Accounts[i].Name == account.Name {
+			a.Accounts[i] = account
+			return
+		}
+	}
+	a.Accounts = append(a.Accounts, account)
+}
+
+// Remove removes the account, and stops it being the current account
+func (a *Accounts) Remove(name string) bool {
+	if a.Current == name {
+		a.Current = ""
+	}
+	for i, account := range a.Accounts {
+		if account.Name == name {
+			a.Accounts = append(a.Accounts[:i], a.Accounts[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Selected returns name if it is set, otherwise the current account
+func (a Accounts) Selected(name string) string {
+	if name != "" {
+		return name
+	}
+	if a.Current != "" {
+		return a.Current
+	}
+	return DefaultAccount
+}
+
+func (a *App) account() string {
+	if a.Account == "" {
+		return DefaultAccount
+	}
+	return a.Account
+}
+
+// AccountFileName adds the account to a file name, so that data never crosses between accounts
+func AccountFileName(account, name string) string {
+	if account == "" || account == DefaultAccount {
+		return name
+	}
+	return account + "." + name
+}
+
+func (a *App) accountName(name string) string {
+	return AccountFileName(a.account(), name)
+}
+
+func (a *App) accountFile(dir, name string) string {
+	return filepath.Join(dir, a.accountName(name))
+}
+
+// recordAccount adds the account to the list after authorising, so that it shows in auth list
+func (a *App) recordAccount() error {
+	accounts, err := LoadAccounts(a.ConfigDir)
+	if err != nil {
+		return err
+	}
+
+	name := a.account()
+	if existing, found := accounts.Find(name); found && existing.Username == a.AuthorisedUsername() {
+		return nil
+	}
+
+	accounts.Add(Account{Name: name, Username: a.AuthorisedUsername()})
+	if accounts.Current == "" {
+		accounts.Current = name
+	}
+	return accounts.Save(a.ConfigDir)
+}
+
+// Logout deletes the token of the account and removes it from the list
+func (a *App) Logout() error {
+	store, err := a.openTokenStore()
+	if err != nil {
+		return err
+	}
+	if err = store.Delete(); err != nil {
+		return fmt.Errorf("failed to delete token: %s", err)
+	}
+
+	accounts, err := LoadAccounts(a.ConfigDir)
+	if err != nil {
+		return err
+	}
+	accounts.Remove(a.account())
+	return accounts.Save(a.ConfigDir)
+}
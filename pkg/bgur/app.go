@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"math/rand"
 	"net/http"
 	"os"
 	"path"
@@ -16,20 +15,44 @@ import (
 	"github.com/m1cr0man/bgur/pkg/imgur"
 )
 
-// These are hard coded on the Imgur app auth page
+// These are hard coded on the Imgur app auth page.
+// The daemon serves the web UI on the same port once authorised.
 const AuthPort = 8099
 const AuthUrl = "/oauthcallback"
 
+// How long the list of images in a folder is cached for
+const DefaultCacheTime = time.Hour * 24 * 7
+
 type App struct {
 	parsedState
 	ConfigDir   string
 	CacheDir    string
 	CacheTime   time.Duration
 	Sync        bool
+	Monitors    []Monitor
+	Schedule    []ScheduleRule
+	Location    *Location
+	CacheBudget CacheBudget
+	// Number of upcoming images to download ahead of time
+	Prefetch            int
+	PrefetchConcurrency int
+	// Serve the current background to other devices while running as a daemon
+	ShareCurrent bool
+	// Where the Imgur token is kept. See TokenStoreEncrypted and co.
+	TokenStore string
+	// Name of the account to use. Files of other accounts are kept separate
+	Account string
+
 	folderOwner string
+	folderName  string
 	folderId    int
+	folderApps  map[string]*App
+	sources     []folderSource
+	conn        *connection
+	prefetcher  prefetcher
 	api         *imgur.API
 	server      *http.Server
+	mux         *http.ServeMux
 	albums      []imgur.Album
 	images      []imgur.Image
 	stateAlbum  imgur.Album
@@ -37,7 +60,10 @@ type App struct {
 }
 
 func (a *App) cacheFile() string {
-	return filepath.Join(a.CacheDir, fmt.Sprintf("cache.%s.%d.json", a.folderOwner, a.folderId))
+	if len(a.sources) > 0 {
+		return a.accountFile(a.CacheDir, fmt.Sprintf("cache.%s.json", a.sourcesId()))
+	}
+	return a.accountFile(a.CacheDir, fmt.Sprintf("cache.%s.%d.json", a.folderOwner, a.folderId))
 }
 
 func (a *App) CountImages() int {
@@ -52,21 +78,39 @@ func (a *App) AuthorisedUsername() string {
 	return a.api.Username
 }
 
-func (a *App) RunServer(shutdownChan chan error) {
-	shutdownChan <- a.server.ListenAndServe()
+// runServer serves the web UI and API until stopServer is called. The web UI is
+// optional, so the daemon carries on without it if the port is taken.
+func (a *App) runServer() {
+	if err := a.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		fmt.Println("Failed to start the web UI: ", err)
+	}
 }
 
-func (a *App) StopServer() {
+func (a *App) stopServer() {
 	_ = a.server.Shutdown(context.Background())
 }
 
-func (a *App) Authorise() error {
-	authFile := filepath.Join(a.ConfigDir, "token.json")
-	return a.api.Authorise(authFile)
+// SetHeadless makes Authorise ask for the redirect address on stdin, for machines without a browser
+func (a *App) SetHeadless(headless bool) {
+	a.api.Headless = headless
+}
+
+func (a *App) Authorise() (err error) {
+	if a.api.Store, err = a.openTokenStore(); err != nil {
+		return
+	}
+	if err = a.api.Authorise(); err != nil {
+		return
+	}
+
+	if err = a.recordAccount(); err != nil {
+		fmt.Println("Failed to save the list of accounts: ", err)
+	}
+	return nil
 }
 
 func (a *App) SelectFolder(folderOwner, folderName string) error {
-	folders, err := a.api.GetFolders(folderOwner)
+	folders, err := a.getFolders(folderOwner)
 	if err != nil {
 		return err
 	}
@@ -75,6 +119,7 @@ func (a *App) SelectFolder(folderOwner, folderName string) error {
 		if strings.ToLower(folder.Name) == strings.ToLower(folderName) {
 			a.folderId = folder.Id
 			a.folderOwner = folderOwner
+			a.folderName = folder.Name
 			return nil
 		}
 	}
@@ -106,19 +151,31 @@ func (a *App) LoadImages() (err error) {
 	err2 := json.Unmarshal(data, &a.images)
 	expired := a.cacheTimestamp.Add(a.CacheTime).Before(time.Now())
 
+	cached := err == nil && err2 == nil
+
+	// Keep using the old list offline, even if it has expired
+	if cached && expired && a.Offline() {
+		expired = false
+	}
+
 	// Any errors with the cache can be ignored, we can rebuild it
-	if err != nil || err2 != nil || expired {
-		newImages, err = a.api.GetFolderImages(a.folderOwner, a.folderId)
-		if a.seed > 0 {
-			rand.Seed(a.seed)
-			Randomise(newImages)
+	if !cached || expired {
+		if a.Offline() {
+			return fmt.Errorf("no cached images available offline")
 		}
+
+		newImages, err = a.fetchImages()
 		if err != nil {
-			return err
+			if !cached || !a.checkOffline(err) {
+				return err
+			}
+			// Imgur is unavailable, fall back to the old list
+			newImages = a.images
+			expired = false
+		} else {
+			a.cacheTimestamp = time.Now()
 		}
 
-		a.cacheTimestamp = time.Now()
-
 	} else {
 		// No errors means we should use the old list
 		newImages = a.images
@@ -129,11 +186,19 @@ func (a *App) LoadImages() (err error) {
 	// There's no way to know if currentImage should be updated because we may
 	// be behind. Might need to store a tuple of (hash, pos) in the sync data.
 	// OR we denote position by the image id??
-	if a.currentImage > 0 && expired && len(a.images) > a.currentImage {
+	// With multiple monitors, everything before the furthest monitor counts as seen
+	var lastSeen int
+	for _, pos := range a.positions() {
+		if pos.currentImage > lastSeen && pos.currentImage < len(a.images) {
+			lastSeen = pos.currentImage
+		}
+	}
+
+	if lastSeen > 0 && expired {
 
 		// Identify all the images we've seen before, preserve them in a separate list
-		seen := make(map[string]imgur.Image, a.currentImage)
-		for _, image := range a.images[:a.currentImage] {
+		seen := make(map[string]imgur.Image, lastSeen)
+		for _, image := range a.images[:lastSeen] {
 			seen[image.Id] = image
 		}
 
@@ -146,8 +211,12 @@ func (a *App) LoadImages() (err error) {
 			}
 		}
 
-		// If some seen images were removed, fix the offset of CurrentImage
-		a.currentImage -= a.currentImage - i
+		// If some seen images were removed, fix the offset of each monitor
+		for _, pos := range a.positions() {
+			if pos.currentImage > i {
+				pos.currentImage = i
+			}
+		}
 	}
 
 	a.images = newImages
@@ -168,42 +237,90 @@ func (a *App) LoadAlbums() (err error) {
 	return
 }
 
-func (a *App) PickImage(expiry time.Duration, minRatio, maxRatio int) (imgur.Image, error) {
+// usable checks if an image can be used as a background on the monitor
+func (a *App) usable(image imgur.Image, monitor Monitor) bool {
+	// Check image MIME and skip animated images
+	if image.Animated || !strings.Contains(image.Type, "image") {
+		return false
+	}
 
-	// Select currentImage if it has not expired
-	currentImage := a.currentImage
-	if a.dateChanged.Add(expiry).After(time.Now()) {
-		return a.images[currentImage], nil
+	// Check ratio and resolution, skip to next image if wrong
+	if !monitor.Accepts(image) {
+		return false
 	}
 
-	// Loop until an appropriate image is found
+	// Only images in the cache can be used offline
+	if a.Offline() && !a.isDownloaded(image) && !a.isDerived(image, monitor) {
+		return false
+	}
+
+	return !a.IsBanned(image.Id)
+}
+
+// findImage steps through the images from start until a usable image is found.
+// If none are found, start is returned.
+func (a *App) findImage(start, step int, monitor Monitor) (int, bool) {
+	pos := start
 	for i := 0; i < len(a.images); i++ {
-		// Increment currentImage
-		currentImage++
-		if currentImage == len(a.images) {
-			currentImage = 0
+		pos = (pos + step + len(a.images)) % len(a.images)
+		if a.usable(a.images[pos], monitor) {
+			return pos, true
 		}
+	}
+	return start, false
+}
 
-		newImage := a.images[currentImage]
+func (a *App) selectImage(monitor Monitor, pos int) imgur.Image {
+	position := a.position(monitor.Name)
+	position.currentImage = pos
+	position.dateChanged = time.Now()
+	position.pinnedUntil = time.Time{}
+	return a.images[pos]
+}
 
-		// Check image MIME and skip animated images
-		if newImage.Animated || !strings.Contains(newImage.Type, "image") {
-			continue
-		}
+func (a *App) PickImage(expiry time.Duration, monitor Monitor) (imgur.Image, error) {
+	if len(a.images) == 0 {
+		return imgur.Image{}, fmt.Errorf("no images loaded")
+	}
 
-		// Check ratio, skip to next image if wrong
-		if (minRatio > 0 && newImage.Ratio() < minRatio) || (maxRatio > 0 && newImage.Ratio() > maxRatio) {
-			continue
+	// Select currentImage if it has not expired or is pinned
+	position := a.position(monitor.Name)
+	if position.nextChange(expiry).After(time.Now()) {
+		if image, ok := a.CurrentImage(monitor); ok {
+			return image, nil
 		}
+	}
 
-		// Select new image
-		a.currentImage = currentImage
-		a.dateChanged = time.Now()
-		return newImage, nil
+	currentImage, found := a.findImage(position.currentImage, 1, monitor)
+	if !found {
+		// No images matched the filter. Return the remaining currentImage
+		return a.images[currentImage%len(a.images)], fmt.Errorf("no new image found. Perhaps filters are too strict")
 	}
 
-	// If the loop exits, then no images matched the filter. Return the remaining currentImage
-	return a.images[currentImage], fmt.Errorf("no new image found. Perhaps filters are too strict")
+	return a.selectImage(monitor, currentImage), nil
+}
+
+// PickPreviousImage goes back to the last image that suits the monitor
+func (a *App) PickPreviousImage(monitor Monitor) (imgur.Image, error) {
+	if len(a.images) == 0 {
+		return imgur.Image{}, fmt.Errorf("no images loaded")
+	}
+
+	previousImage, found := a.findImage(a.position(monitor.Name).currentImage, -1, monitor)
+	if !found {
+		return a.images[previousImage%len(a.images)], fmt.Errorf("no previous image found. Perhaps filters are too strict")
+	}
+
+	return a.selectImage(monitor, previousImage), nil
+}
+
+// CurrentImage returns the image currently used as the background on the monitor
+func (a *App) CurrentImage(monitor Monitor) (image imgur.Image, ok bool) {
+	currentImage := a.position(monitor.Name).currentImage
+	if currentImage < 0 || currentImage >= len(a.images) {
+		return
+	}
+	return a.images[currentImage], true
 }
 
 func (a *App) DownloadImage(image imgur.Image) (imgPath string, err error) {
@@ -214,13 +331,178 @@ func (a *App) DownloadImage(image imgur.Image) (imgPath string, err error) {
 		return
 	}
 
+	if a.Offline() {
+		return imgPath, fmt.Errorf("cannot download %s while offline", image.Link)
+	}
+
 	imgData, err := a.api.DownloadImage(image.Link)
 
 	if err != nil {
+		a.checkOffline(err)
 		return
 	}
 
-	err = ioutil.WriteFile(imgPath, imgData, 0644)
+	err = writeFileAtomic(imgPath, imgData)
+	return
+}
+
+// ExpireBackground makes the background of every monitor due for a change
+func (a *App) ExpireBackground() {
+	a.expireMonitors(a.GetMonitors())
+}
+
+func (a *App) expireMonitors(monitors []Monitor) {
+	for _, monitor := range monitors {
+		if app, err := a.monitorApp(monitor); err == nil {
+			app.position(monitor.Name).expire()
+		}
+	}
+}
+
+// PinBackground keeps the current background of every monitor for at least duration
+func (a *App) PinBackground(duration time.Duration) error {
+	return a.pinMonitors(a.GetMonitors(), duration)
+}
+
+func (a *App) pinMonitors(monitors []Monitor, duration time.Duration) error {
+	apps := make(map[*App]bool)
+	for _, monitor := range monitors {
+		app, err := a.monitorApp(monitor)
+		if err != nil {
+			return err
+		}
+		app.position(monitor.Name).pinnedUntil = time.Now().Add(duration)
+		apps[app] = true
+	}
+	return saveApps(apps)
+}
+
+func pickNext(expiry time.Duration) func(*App, Monitor) (imgur.Image, error) {
+	return func(app *App, monitor Monitor) (imgur.Image, error) {
+		return app.PickImage(expiry, monitor)
+	}
+}
+
+func pickPrevious(app *App, monitor Monitor) (imgur.Image, error) {
+	return app.PickPreviousImage(monitor)
+}
+
+// ChangeBackground changes the background of every monitor which is due a change.
+// The image cache and state are saved afterwards so that the change is synced.
+func (a *App) ChangeBackground(expiry time.Duration) error {
+	return a.changeBackground(a.GetMonitors(), pickNext(expiry))
+}
+
+// PreviousBackground sets the background of every monitor back to the previous image
+func (a *App) PreviousBackground() error {
+	return a.changeBackground(a.GetMonitors(), pickPrevious)
+}
+
+// changeBackground picks and applies an image for each monitor. A monitor which fails
+// doesn't stop the others, and the state of those which changed is always saved.
+func (a *App) changeBackground(monitors []Monitor, pick func(*App, Monitor) (imgur.Image, error)) error {
+	apps := make(map[*App]bool)
+	var failures []string
+
+	for _, monitor := range monitors {
+		app, err := a.changeMonitor(monitor, pick)
+		if app != nil {
+			apps[app] = true
+		}
+		if err != nil {
+			failures = append(failures, monitorError(monitor, err))
+		}
+	}
+
+	if _, err := a.PruneCache(); err != nil {
+		fmt.Println("Failed to prune cache: ", err)
+	}
+
+	if err := saveApps(apps); err != nil {
+		failures = append(failures, err.Error())
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// changeMonitor changes the background of one monitor. The App of its folder is
+// returned whenever its state may have changed, even if there was an error.
+func (a *App) changeMonitor(monitor Monitor, pick func(*App, Monitor) (imgur.Image, error)) (*App, error) {
+	app, err := a.monitorApp(monitor)
+	if err != nil {
+		return nil, err
+	}
+
+	lastChanged := app.position(monitor.Name).dateChanged
+	image, err := pick(app, monitor)
+	if err != nil {
+		return app, err
+	}
+
+	// Imgur went away while downloading. Pick again from the images in the cache
+	if err = app.applyImage(monitor, image); err != nil && app.Offline() {
+		// app is already the folder of the monitor, and has no schedule to find it again
+		app.position(monitor.Name).expire()
+		if image, err = app.PickImage(0, monitor); err != nil {
+			return app, err
+		}
+		err = app.applyImage(monitor, image)
+	}
+	if err != nil {
+		fmt.Println("Failed to set desktop background: ", err)
+	} else if !app.position(monitor.Name).dateChanged.Equal(lastChanged) {
+		if err = app.recordHistory(monitor, image); err != nil {
+			fmt.Println("Failed to record history: ", err)
+		}
+	}
+	return app, nil
+}
+
+// monitorError names the monitor in err, if there is more than the unnamed one
+func monitorError(monitor Monitor, err error) string {
+	if monitor.Name == "" {
+		return err.Error()
+	}
+	return fmt.Sprintf("monitor %s: %s", monitor.Name, err)
+}
+
+func (a *App) applyImage(monitor Monitor, image imgur.Image) error {
+	if monitor.Name != "" {
+		fmt.Println("Using", image.Link, "as desktop background on", monitor.Name)
+	} else {
+		fmt.Println("Using", image.Link, "as desktop background")
+	}
+
+	imagePath, err := a.backgroundFile(image, monitor)
+	if err != nil {
+		return fmt.Errorf("failed to download image: %s", err)
+	}
+	markShown(imagePath)
+	if original := a.imageFile(image); original != imagePath {
+		// The original is needed again if the resized file is removed, so keep it as long
+		markShown(original)
+	}
+
+	return monitor.SetBackground(imagePath)
+}
+
+// saveApps saves the image cache and state of each App after a change
+func saveApps(apps map[*App]bool) (err error) {
+	for app := range apps {
+		// Save images after picking so that DateSeen is saved
+		if err = app.SaveImages(); err != nil {
+			fmt.Println("Failed to save cache of images: ", err, " This will slow down subsequent runs")
+		}
+
+		if app.Sync {
+			fmt.Println("Saving state to imgur")
+		}
+		if err = app.SaveState(); err != nil {
+			return fmt.Errorf("failed to save state: %s", err)
+		}
+	}
 	return
 }
 
@@ -231,6 +513,9 @@ func (a *App) SetSeed(seed int64) {
 	}
 }
 
+// FavouritesFile is where DumpFavourites saves the favourites, in the working directory
+const FavouritesFile = "favourites.json"
+
 func (a *App) DumpFavourites(folderOwner string) (err error) {
 	data, err := a.api.GetFavourites(folderOwner)
 	if err != nil {
@@ -240,7 +525,8 @@ func (a *App) DumpFavourites(folderOwner string) (err error) {
 	if err != nil {
 		return
 	}
-	return ioutil.WriteFile("favourites.json", js, 0440)
+	// Replaced each time, so exporting again works
+	return writeFileAtomic(FavouritesFile, js)
 }
 
 func (a *App) UploadAllImages(sourcePath, albumName string) (err error) {
@@ -366,12 +652,15 @@ func (a *App) AddAlbumToFolder(albumId string) (err error) {
 }
 
 func NewApp(configDir, cacheDir string, cacheTime time.Duration, sync bool) *App {
+	mux := http.NewServeMux()
 	return &App{
 		ConfigDir: configDir,
 		CacheDir:  cacheDir,
 		CacheTime: cacheTime,
 		Sync:      sync,
-		server:    &http.Server{Addr: fmt.Sprintf(":%d", AuthPort)},
-		api:       imgur.NewAPI(AuthUrl),
+		server:    &http.Server{Addr: fmt.Sprintf(":%d", AuthPort), Handler: mux},
+		mux:       mux,
+		conn:      &connection{},
+		api:       imgur.NewAPI(fmt.Sprintf("http://localhost:%d%s", AuthPort, AuthUrl)),
 	}
 }
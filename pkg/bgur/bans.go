@@ -0,0 +1,123 @@
+package bgur
+
+import (
+	"fmt"
+	"time"
+)
+
+// IsBanned checks if an image has been banned from being used as a background
+func (a *App) IsBanned(imageId string) bool {
+	for _, banned := range a.banned {
+		if banned == imageId {
+			return true
+		}
+	}
+	return false
+}
+
+// BannedImages lists the ids of every image banned in any folder
+func (a *App) BannedImages() (banned []string) {
+	seen := make(map[string]bool)
+	for _, app := range a.allApps() {
+		for _, imageId := range app.banned {
+			if !seen[imageId] {
+				seen[imageId] = true
+				banned = append(banned, imageId)
+			}
+		}
+	}
+	return
+}
+
+// BanImage stops an image from being used as a background again
+func (a *App) BanImage(imageId string) error {
+	if !a.IsBanned(imageId) {
+		a.banned = append(a.banned, imageId)
+	}
+	return a.SaveState()
+}
+
+// UnbanImage allows a banned image to be used as a background again
+func (a *App) UnbanImage(imageId string) error {
+	for i, banned := range a.banned {
+		if banned == imageId {
+			a.banned = append(a.banned[:i:i], a.banned[i+1:]...)
+			return a.SaveState()
+		}
+	}
+	return fmt.Errorf("image %s is not banned", imageId)
+}
+
+// mergeBans adds the bans from another state. Used when state saved offline is
+// replaced by newer state from Imgur, so that bans made offline aren't lost.
+// Returns true if the other state was missing some bans.
+func (a *App) mergeBans(other parsedState) (missing bool) {
+	for _, imageId := range other.banned {
+		if !a.IsBanned(imageId) {
+			a.banned = append(a.banned, imageId)
+			missing = true
+		}
+	}
+	return
+}
+
+// BanBackground bans an image in every folder, changing the background of any monitor that shows it.
+// If imageId is empty, the current image of the first selected monitor is banned.
+func (a *App) BanBackground(monitors []Monitor, imageId string, expiry time.Duration) error {
+	if imageId == "" {
+		app, err := a.monitorApp(monitors[0])
+		if err != nil {
+			return err
+		}
+
+		current, ok := app.CurrentImage(monitors[0])
+		if !ok {
+			return fmt.Errorf("no background is set")
+		}
+		imageId = current.Id
+	}
+
+	// The same image can be in more than one folder
+	for _, app := range a.allApps() {
+		if err := app.BanImage(imageId); err != nil {
+			return err
+		}
+	}
+
+	// Don't leave a banned image as the background
+	var banned []Monitor
+	for _, monitor := range a.GetMonitors() {
+		app, err := a.monitorApp(monitor)
+		if err != nil {
+			continue
+		}
+		if current, ok := app.CurrentImage(monitor); ok && current.Id == imageId {
+			banned = append(banned, monitor)
+		}
+	}
+	if len(banned) == 0 {
+		return nil
+	}
+
+	a.expireMonitors(banned)
+	return a.changeBackground(banned, pickNext(expiry))
+}
+
+// UnbanBackground unbans an image in every folder
+func (a *App) UnbanBackground(imageId string) (err error) {
+	var unbanned bool
+	for _, app := range a.allApps() {
+		if !app.IsBanned(imageId) {
+			continue
+		}
+		if err = app.UnbanImage(imageId); err != nil {
+			return
+		}
+		unbanned = true
+	}
+
+	if !unbanned {
+		return fmt.Errorf("image %s is not banned", imageId)
+	}
+	return
+}
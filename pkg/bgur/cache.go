@@ -0,0 +1,137 @@
+package bgur
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CacheBudget limits the size of the image cache. Zero means unlimited
+type CacheBudget struct {
+	MaxBytes int64 `json:"max_bytes,omitempty"`
+	MaxFiles int   `json:"max_files,omitempty"`
+}
+
+func (b CacheBudget) Limited() bool {
+	return b.MaxBytes > 0 || b.MaxFiles > 0
+}
+
+func (b CacheBudget) exceeded(stats CacheStats) bool {
+	return (b.MaxBytes > 0 && stats.Bytes > b.MaxBytes) || (b.MaxFiles > 0 && stats.Files > b.MaxFiles)
+}
+
+type CacheStats struct {
+	Files int
+	Bytes int64
+	// Time the least recently shown image was last shown
+	Oldest time.Time
+}
+
+// cachedImages lists the downloaded images in the cache, least recently shown first.
+// The modification time of each image is updated whenever it is shown.
+func cachedImages(cacheDir string) (images []os.FileInfo, err error) {
+	files, err := ioutil.ReadDir(cacheDir)
+	if err != nil {
+		return
+	}
+
+	for _, file := range files {
+		// Skip the folder and image list caches, and unfinished downloads
+		if file.IsDir() || strings.HasSuffix(file.Name(), ".json") || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		images = append(images, file)
+	}
+
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].ModTime().Before(images[j].ModTime())
+	})
+	return
+}
+
+func statCache(images []os.FileInfo) (stats CacheStats) {
+	for _, image := range images {
+		stats.Files++
+		stats.Bytes += image.Size()
+	}
+	if len(images) > 0 {
+		stats.Oldest = images[0].ModTime()
+	}
+	return
+}
+
+// GetCacheStats reads the size of the image cache. It doesn't need an App, so it works without Imgur
+func GetCacheStats(cacheDir string) (stats CacheStats, err error) {
+	images, err := cachedImages(cacheDir)
+	return statCache(images), err
+}
+
+// markShown records that the image was shown, so that it is kept in the cache longer
+func markShown(imagePath string) {
+	now := time.Now()
+	_ = os.Chtimes(imagePath, now, now)
+}
+
+// protectedFiles returns the cached files which must not be evicted
+func (a *App) protectedFiles() map[string]bool {
+	protected := make(map[string]bool)
+	for _, monitor := range a.GetMonitors() {
+		app, err := a.monitorApp(monitor)
+		if err != nil {
+			continue
+		}
+		if image, ok := app.CurrentImage(monitor); ok {
+			protected[filepath.Base(app.imageFile(image))] = true
+			if monitor.resizes() {
+				protected[filepath.Base(app.derivedFile(image, monitor))] = true
+			}
+		}
+	}
+
+	// Don't throw away prefetched images before they are shown
+	for path := range a.upcomingImages() {
+		protected[filepath.Base(path)] = true
+	}
+	return protected
+}
+
+// PruneCache removes the least recently shown images until the cache is within CacheBudget.
+// The current and upcoming backgrounds of each monitor are never removed.
+func (a *App) PruneCache() (removed []string, err error) {
+	if !a.CacheBudget.Limited() {
+		return
+	}
+
+	images, err := cachedImages(a.CacheDir)
+	if err != nil {
+		return
+	}
+
+	stats := statCache(images)
+	protected := a.protectedFiles()
+	for _, image := range images {
+		if !a.CacheBudget.exceeded(stats) {
+			break
+		}
+		if protected[image.Name()] {
+			continue
+		}
+
+		if err = os.Remove(filepath.Join(a.CacheDir, image.Name())); err != nil {
+			return
+		}
+		stats.Files--
+		stats.Bytes -= image.Size()
+		removed = append(removed, image.Name())
+	}
+
+	if a.CacheBudget.exceeded(stats) {
+		err = fmt.Errorf("cache is still over budget with %d files and %s. "+
+			"Only images in use are left", stats.Files, FormatBytes(stats.Bytes))
+	}
+	return
+}
@@ -0,0 +1,73 @@
+package bgur
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+)
+
+// Environment variables which override the imgur section of the config
+const (
+	ClientIDEnv     = "BGUR_CLIENT_ID"
+	ClientSecretEnv = "BGUR_CLIENT_SECRET"
+	CallbackURLEnv  = "BGUR_CALLBACK_URL"
+)
+
+// ImgurClient is the Imgur app which bgur authorises as. Registering your own at
+// https://api.imgur.com/oauth2/addclient gives you a rate limit of your own.
+// Empty settings keep the app registered for bgur.
+type ImgurClient struct {
+	ClientID string `json:"client_id,omitempty"`
+	// Only for apps registered as confidential clients. Better kept in ClientSecretEnv
+	ClientSecret string `json:"client_secret,omitempty"`
+	// Must match the callback URL of the app. Defaults to localhost on AuthPort
+	CallbackURL string `json:"callback_url,omitempty"`
+}
+
+// WithEnv returns the settings with any set environment variables applied
+func (c ImgurClient) WithEnv() ImgurClient {
+	if clientID := os.Getenv(ClientIDEnv); clientID != "" && clientID != c.ClientID {
+		// The secret in the config belongs to another app
+		c.ClientID = clientID
+		c.ClientSecret = ""
+	}
+	if clientSecret := os.Getenv(ClientSecretEnv); clientSecret != "" {
+		c.ClientSecret = clientSecret
+	}
+	if callbackURL := os.Getenv(CallbackURLEnv); callbackURL != "" {
+		c.CallbackURL = callbackURL
+	}
+	return c
+}
+
+func (c ImgurClient) Validate() error {
+	if c.ClientSecret != "" && c.ClientID == "" {
+		return fmt.Errorf("client_secret needs the client_id of the same app")
+	}
+
+	if c.CallbackURL != "" {
+		// bgur receives the callback itself, so it must be plain http
+		callback, err := url.Parse(c.CallbackURL)
+		if err != nil || callback.Scheme != "http" || callback.Host == "" {
+			return fmt.Errorf("invalid callback_url %s. Use an address such as http://localhost:%d%s",
+				c.CallbackURL, AuthPort, AuthUrl)
+		}
+	}
+	return nil
+}
+
+// SetImgurClient chooses the Imgur app to authorise as, after applying the environment.
+// Tokens belong to one app, so changing it means authorising again.
+func (a *App) SetImgurClient(client ImgurClient) error {
+	client = client.WithEnv()
+	if err := client.Validate(); err != nil {
+		return err
+	}
+
+	a.api.ClientID = client.ClientID
+	a.api.ClientSecret = client.ClientSecret
+	if client.CallbackURL != "" {
+		a.api.CallbackURL = client.CallbackURL
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package bgur
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const ConfigFileName = "config.json"
+
+// Config holds settings which are too complex for flags
+type Config struct {
+	Monitors []Monitor `json:"monitors,omitempty"`
+	// Folders to merge into one rotation instead of -folder-name
+	Folders []FolderSource `json:"folders,omitempty"`
+	// Folders to switch to at certain times, in order of priority
+	Schedule []ScheduleRule `json:"schedule,omitempty"`
+	// Used to work out sunrise and sunset for the schedule
+	Location *Location   `json:"location,omitempty"`
+	Cache    CacheBudget `json:"cache,omitempty"`
+	// Number of upcoming images to download ahead of time
+	Prefetch            int `json:"prefetch,omitempty"`
+	PrefetchConcurrency int `json:"prefetch_concurrency,omitempty"`
+	// Serve the current background to other devices at CurrentPath
+	ShareCurrent bool `json:"share_current,omitempty"`
+	// Where the Imgur token is kept. See TokenStoreEncrypted and co.
+	TokenStore string `json:"token_store,omitempty"`
+	// Imgur app to authorise as, instead of the one registered for bgur
+	Imgur ImgurClient `json:"imgur,omitempty"`
+	// Named sets of settings, selected with -profile
+	Profiles map[string]Profile `json:"profiles,omitempty"`
+}
+
+// Profile holds settings for one use of bgur, such as a single monitor or machine.
+// Flags given on the command line override the profile.
+type Profile struct {
+	FolderName  string `json:"folder_name,omitempty"`
+	FolderOwner string `json:"folder_owner,omitempty"`
+	// Minutes between background changes
+	ChangeInterval int    `json:"change_interval,omitempty"`
+	MinRatio       int    `json:"min_ratio,omitempty"`
+	MaxRatio       int    `json:"max_ratio,omitempty"`
+	Resolution     string `json:"resolution,omitempty"`
+	Fit            string `json:"fit,omitempty"`
+	AcceptAnyRatio bool   `json:"accept_any_ratio,omitempty"`
+	Sync           bool   `json:"sync,omitempty"`
+	Seed           *int64 `json:"seed,omitempty"`
+	// Account from bgur auth list
+	Account string `json:"account,omitempty"`
+	// Replace the top level sections of the config when set
+	Monitors []Monitor      `json:"monitors,omitempty"`
+	Folders  []FolderSource `json:"folders,omitempty"`
+	Schedule []ScheduleRule `json:"schedule,omitempty"`
+}
+
+func ConfigFile(configDir string) string {
+	return filepath.Join(configDir, ConfigFileName)
+}
+
+// LoadConfig reads the config file from configDir. A missing file is not an error
+func LoadConfig(configDir string) (config Config, err error) {
+	data, err := ioutil.ReadFile(ConfigFile(configDir))
+	if os.IsNotExist(err) {
+		return config, nil
+	} else if err != nil {
+		return
+	}
+
+	if err = json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("failed to parse %s: %s", ConfigFile(configDir), err)
+	}
+
+	if err = config.validate(); err != nil {
+		return config, fmt.Errorf("%s: %s", ConfigFile(configDir), err)
+	}
+
+	for name, profile := range config.Profiles {
+		profileConfig := config
+		profileConfig.useSections(profile)
+		if err = profileConfig.validate(); err == nil && profile.Account != "" {
+			err = ValidateAccountName(profile.Account)
+		}
+		if err != nil {
+			return config, fmt.Errorf("%s: profile %s: %s", ConfigFile(configDir), name, err)
+		}
+	}
+	return
+}
+
+func (c Config) validate() (err error) {
+	if err = ValidateTokenStore(c.TokenStore); err != nil {
+		return
+	}
+	if err = c.Imgur.Validate(); err != nil {
+		return fmt.Errorf("imgur: %s", err)
+	}
+
+	for i, monitor := range c.Monitors {
+		if monitor.Name == "" {
+			return fmt.Errorf("monitor %d has no name", i+1)
+		}
+	}
+
+	for i, source := range c.Folders {
+		if source.Name == "" {
+			return fmt.Errorf("folder %d has no name", i+1)
+		}
+		if source.Weight < 0 {
+			return fmt.Errorf("folder %s has a negative weight", source.Name)
+		}
+	}
+
+	if c.Location != nil {
+		if err = c.Location.Validate(); err != nil {
+			return fmt.Errorf("location: %s", err)
+		}
+	}
+
+	for i, rule := range c.Schedule {
+		if err = rule.Validate(c.Location); err != nil {
+			return fmt.Errorf("schedule rule %d: %s", i+1, err)
+		}
+	}
+	return
+}
+
+// useSections replaces the sections of the config which are set in the profile
+func (c *Config) useSections(profile Profile) {
+	if len(profile.Monitors) > 0 {
+		c.Monitors = profile.Monitors
+	}
+	if len(profile.Folders) > 0 {
+		c.Folders = profile.Folders
+	} else if profile.FolderName != "" {
+		// A single folder in the profile overrides the merged folders
+		c.Folders = nil
+	}
+	if len(profile.Schedule) > 0 {
+		c.Schedule = profile.Schedule
+	}
+}
+
+// UseProfile applies the sections of a named profile to the config.
+// The rest of the profile is returned so that it can be applied to the flags.
+func (c *Config) UseProfile(name string) (profile Profile, err error) {
+	profile, found := c.Profiles[name]
+	if !found {
+		names := make([]string, 0, len(c.Profiles))
+		for name := range c.Profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return profile, fmt.Errorf("no profile called %s. Options: %s", name, strings.Join(names, ", "))
+	}
+
+	c.useSections(profile)
+	return
+}
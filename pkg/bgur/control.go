@@ -0,0 +1,303 @@
+package bgur
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/m1cr0man/bgur/pkg/imgur"
+)
+
+const ControlSocketName = "control.sock"
+
+// How long a control request will wait for the daemon to pick it up
+const controlTimeout = time.Minute
+
+const (
+	CommandNext     = "next"
+	CommandPrevious = "previous"
+	CommandPin      = "pin"
+	CommandBan      = "ban"
+	CommandStatus   = "status"
+	CommandShow     = "show"
+	CommandUnban    = "unban"
+	CommandBans     = "bans"
+	CommandImages   = "images"
+	CommandRefresh  = "refresh"
+)
+
+// Number of upcoming images to include in the status
+const statusUpcoming = 5
+
+type ControlRequest struct {
+	Command string `json:"command"`
+	// Monitor to apply the command to. Defaults to all monitors
+	Monitor string `json:"monitor,omitempty"`
+	// Duration to pin the background for, in time.ParseDuration format
+	Duration string `json:"duration,omitempty"`
+	// Image to ban or show. Defaults to the current image for bans
+	ImageId string `json:"image_id,omitempty"`
+}
+
+type MonitorStatus struct {
+	Monitor     string      `json:"monitor,omitempty"`
+	Image       imgur.Image `json:"image"`
+	Position    int         `json:"position"`
+	Total       int         `json:"total"`
+	Folder      string      `json:"folder"`
+	DateChanged string      `json:"date_changed"`
+	NextChange  string      `json:"next_change"`
+	PinnedUntil string      `json:"pinned_until,omitempty"`
+	// The images which will be shown next
+	Upcoming []imgur.Image `json:"upcoming,omitempty"`
+}
+
+// ImageInfo describes an image in the folder of a monitor
+type ImageInfo struct {
+	imgur.Image
+	Position   int  `json:"position"`
+	Downloaded bool `json:"downloaded"`
+	// Name of the file in the cache, resized for the monitor if possible
+	CachedFile string `json:"cached_file,omitempty"`
+	Banned     bool   `json:"banned"`
+	// Whether the image suits the monitor
+	Usable bool `json:"usable"`
+}
+
+type Status struct {
+	Monitors []MonitorStatus `json:"monitors"`
+}
+
+type ControlResponse struct {
+	Success bool     `json:"success"`
+	Error   string   `json:"error,omitempty"`
+	Status  Status   `json:"status"`
+	Banned  []string `json:"banned,omitempty"`
+	// Images in the folder of the monitor, for CommandImages
+	Images []ImageInfo `json:"images,omitempty"`
+}
+
+// Requests are passed to the daemon loop so that only one goroutine touches the App
+type controlCall struct {
+	request  ControlRequest
+	response chan ControlResponse
+}
+
+// ControlSocket returns the socket of the daemon for the account. Each account has its own daemon
+func ControlSocket(configDir, account string) string {
+	return filepath.Join(configDir, AccountFileName(account, ControlSocketName))
+}
+
+func (a *App) listenControl() (net.Listener, error) {
+	socket := ControlSocket(a.ConfigDir, a.account())
+
+	// Clean up the socket left behind by a daemon which didn't exit cleanly
+	if _, err := os.Stat(socket); err == nil {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("bgur is already running. Control socket %s is in use", socket)
+		}
+		if err = os.Remove(socket); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the current user should be able to control bgur
+	if err = os.Chmod(socket, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+func serveControl(listener net.Listener, calls chan<- controlCall) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			// Listener was closed
+			return
+		}
+		go handleControlConn(conn, calls)
+	}
+}
+
+func handleControlConn(conn net.Conn, calls chan<- controlCall) {
+	defer conn.Close()
+
+	var request ControlRequest
+	if err := json.NewDecoder(conn).Decode(&request); err != nil {
+		response := ControlResponse{Error: fmt.Sprintf("failed to parse request: %s", err)}
+		_ = json.NewEncoder(conn).Encode(response)
+		return
+	}
+
+	_ = json.NewEncoder(conn).Encode(callDaemon(calls, request))
+}
+
+// callDaemon passes the request to the daemon loop and waits for the response
+func callDaemon(calls chan<- controlCall, request ControlRequest) (response ControlResponse) {
+	call := controlCall{request: request, response: make(chan ControlResponse, 1)}
+	select {
+	case calls <- call:
+		return <-call.response
+	case <-time.After(controlTimeout):
+		response.Error = "timed out waiting for bgur to respond"
+	}
+	return
+}
+
+func (a *App) GetStatus(expiry time.Duration) (status Status) {
+	for _, monitor := range a.GetMonitors() {
+		app, err := a.monitorApp(monitor)
+		if err != nil {
+			continue
+		}
+
+		position := app.position(monitor.Name)
+		monitorStatus := MonitorStatus{
+			Monitor:     monitor.Name,
+			Position:    position.currentImage,
+			Total:       len(app.images),
+			Folder:      app.folderName,
+			DateChanged: position.dateChanged.Format(TimeFormat),
+			NextChange:  position.nextChange(expiry).Format(TimeFormat),
+		}
+		monitorStatus.Image, _ = app.CurrentImage(monitor)
+		monitorStatus.Upcoming = app.upcoming(monitor, statusUpcoming)
+		if position.pinnedUntil.After(time.Now()) {
+			monitorStatus.PinnedUntil = position.pinnedUntil.Format(TimeFormat)
+		}
+		status.Monitors = append(status.Monitors, monitorStatus)
+	}
+	return
+}
+
+// GetImages lists the images in the folder of the monitor, in the order they will be shown
+func (a *App) GetImages(monitor Monitor) ([]ImageInfo, error) {
+	app, err := a.monitorApp(monitor)
+	if err != nil {
+		return nil, err
+	}
+
+	images := make([]ImageInfo, len(app.images))
+	for i, image := range app.images {
+		images[i] = ImageInfo{
+			Image:    image,
+			Position: i,
+			Banned:   app.IsBanned(image.Id),
+			Usable:   app.usable(image, monitor),
+		}
+		if app.isDerived(image, monitor) {
+			images[i].CachedFile = filepath.Base(app.derivedFile(image, monitor))
+		} else if app.isDownloaded(image) {
+			images[i].CachedFile = filepath.Base(app.imageFile(image))
+		}
+		images[i].Downloaded = images[i].CachedFile != ""
+	}
+	return images, nil
+}
+
+// selectMonitors returns the named monitor, or all monitors if name is empty
+func (a *App) selectMonitors(name string) ([]Monitor, error) {
+	if name == "" {
+		return a.GetMonitors(), nil
+	}
+	monitor, err := a.GetMonitor(name)
+	return []Monitor{monitor}, err
+}
+
+// HandleControl runs a control request. The daemon calls this for requests from the
+// control socket, and it can be called directly when no daemon is running.
+func (a *App) HandleControl(request ControlRequest, expiry time.Duration) (response ControlResponse) {
+	monitors, err := a.selectMonitors(request.Monitor)
+
+	if err == nil {
+		switch request.Command {
+		case CommandNext:
+			a.expireMonitors(monitors)
+			err = a.changeBackground(monitors, pickNext(expiry))
+
+		case CommandPrevious:
+			err = a.changeBackground(monitors, pickPrevious)
+
+		case CommandPin:
+			var duration time.Duration
+			duration, err = time.ParseDuration(request.Duration)
+			if err == nil {
+				err = a.pinMonitors(monitors, duration)
+			}
+
+		case CommandBan:
+			err = a.BanBackground(monitors, request.ImageId, expiry)
+
+		case CommandUnban:
+			err = a.UnbanBackground(request.ImageId)
+
+		case CommandBans:
+
+		case CommandShow:
+			err = a.ShowImage(monitors, request.ImageId)
+
+		case CommandStatus:
+
+		case CommandImages:
+			response.Images, err = a.GetImages(monitors[0])
+
+		case CommandRefresh:
+			err = a.RefreshImages()
+
+		default:
+			err = fmt.Errorf("unknown command %s", request.Command)
+		}
+	}
+
+	response.Success = err == nil
+	response.Status = a.GetStatus(expiry)
+	if request.Command == CommandBans {
+		response.Banned = a.BannedImages()
+	}
+	if err != nil {
+		response.Error = err.Error()
+	}
+	return
+}
+
+// DaemonRunning checks if a daemon is listening on the control socket
+func DaemonRunning(configDir, account string) bool {
+	conn, err := net.Dial("unix", ControlSocket(configDir, account))
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// SendControl sends a request to the daemon running with the same config dir and account
+func SendControl(configDir, account string, request ControlRequest) (response ControlResponse, err error) {
+	conn, err := net.Dial("unix", ControlSocket(configDir, account))
+	if err != nil {
+		return response, fmt.Errorf("could not connect to bgur. Is the daemon running? %s", err)
+	}
+	defer conn.Close()
+
+	if err = json.NewEncoder(conn).Encode(request); err != nil {
+		return
+	}
+
+	if err = json.NewDecoder(conn).Decode(&response); err != nil {
+		return
+	}
+
+	if !response.Success {
+		err = fmt.Errorf("%s", response.Error)
+	}
+	return
+}
@@ -0,0 +1,125 @@
+package bgur
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/m1cr0man/bgur/pkg/imgur"
+)
+
+// CurrentPath serves the current background when ShareCurrent is set
+const CurrentPath = "/current.jpg"
+
+// Largest size which can be asked for from CurrentPath, to keep resizing cheap
+const currentMaxSize = 8192
+
+// Widest or tallest ratio which can be asked for from CurrentPath
+const currentMaxRatio = 16
+
+// currentContent returns the background on the monitor, resized to size if it is
+// given. Nothing is downloaded, the daemon has done that already. Sizes other than
+// the monitor's own are resized every time rather than filling up the cache.
+func (a *App) currentContent(image imgur.Image, monitor Monitor, size Monitor) (name string, content []byte, err error) {
+	original := a.imageFile(image)
+	if !size.resizes() {
+		if !monitor.resizes() {
+			content, err = ioutil.ReadFile(original)
+			return filepath.Base(original), content, err
+		}
+		size.Width, size.Height = monitor.Width, monitor.Height
+		if size.Fit == "" {
+			size.Fit = monitor.Fit
+		}
+	}
+
+	if size.Width == monitor.Width && size.Height == monitor.Height && size.fit() == monitor.fit() {
+		derived := a.derivedFile(image, size)
+		if _, err = os.Stat(derived); err != nil {
+			if derived, err = a.fitImage(original, image, size); err != nil {
+				return
+			}
+		}
+		content, err = ioutil.ReadFile(derived)
+		return filepath.Base(derived), content, err
+	}
+
+	content, err = resizeImage(original, size)
+	return CurrentPath, content, err
+}
+
+// serveCurrent serves the background of a monitor at CurrentPath to anybody who can
+// reach the server, so that phones and tablets can show the same background.
+// It takes these query parameters:
+//
+//	monitor  the monitor to show the background of. Defaults to the first
+//	size     resize the image to WIDTHxHEIGHT
+//	fit      how to fit the image to size. crop, fit or blur
+func (a *App) serveCurrent(calls chan<- controlCall) {
+	a.mux.HandleFunc(CurrentPath, func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			http.Error(resp, "expected a GET request", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := req.URL.Query()
+		size := Monitor{Fit: query.Get("fit")}
+		if value := query.Get("size"); value != "" {
+			if _, err := fmt.Sscanf(value, "%dx%d", &size.Width, &size.Height); err != nil ||
+				!size.resizes() || size.Width > currentMaxSize || size.Height > currentMaxSize {
+				http.Error(resp, fmt.Sprintf("size must be WIDTHxHEIGHT, up to %d", currentMaxSize), http.StatusBadRequest)
+				return
+			}
+			if size.Width > size.Height*currentMaxRatio || size.Height > size.Width*currentMaxRatio {
+				http.Error(resp, fmt.Sprintf("size can't be wider or taller than %d:1", currentMaxRatio), http.StatusBadRequest)
+				return
+			}
+		}
+		switch size.fit() {
+		case FitCrop, FitContain, FitBlur:
+		default:
+			http.Error(resp, "fit must be crop, fit or blur", http.StatusBadRequest)
+			return
+		}
+
+		// Find out what is being shown through the daemon loop, the App isn't safe to use here
+		response := callDaemon(calls, ControlRequest{Command: CommandStatus, Monitor: query.Get("monitor")})
+		if !response.Success || len(response.Status.Monitors) == 0 || response.Status.Monitors[0].Image.Id == "" {
+			http.Error(resp, fmt.Sprintf("no background: %s", response.Error), http.StatusNotFound)
+			return
+		}
+		status := response.Status.Monitors[0]
+		monitor, err := a.GetMonitor(status.Monitor)
+		if err != nil {
+			http.Error(resp, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		info, err := os.Stat(a.imageFile(status.Image))
+		if err != nil {
+			http.Error(resp, fmt.Sprintf("background is not available: %s", err), http.StatusServiceUnavailable)
+			return
+		}
+
+		// Clients have to check for a new background every time, which is cheap thanks to the ETag.
+		// It changes with the image and the file, so a different size or fit is a different ETag.
+		etag := fmt.Sprintf(`"%s-%dx%d-%s-%d"`, status.Image.Id, size.Width, size.Height, size.fit(), info.ModTime().Unix())
+		resp.Header().Set("Cache-Control", "no-cache")
+		resp.Header().Set("ETag", etag)
+		if req.Header.Get("If-None-Match") == etag {
+			// Save resizing again
+			resp.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		name, content, err := a.currentContent(status.Image, monitor, size)
+		if err != nil {
+			http.Error(resp, fmt.Sprintf("background is not available: %s", err), http.StatusServiceUnavailable)
+			return
+		}
+		http.ServeContent(resp, req, name, info.ModTime(), bytes.NewReader(content))
+	})
+}
@@ -0,0 +1,188 @@
+package bgur
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Timers don't advance while the machine is suspended, so never sleep longer
+// than this before checking the clock again
+const daemonMaxSleep = time.Minute * 5
+
+// Avoids a busy loop when a change keeps failing
+const daemonMinSleep = time.Minute
+
+// How long to wait before refreshing the images again after it failed,
+// so that a deleted folder doesn't use up the rate limit
+const refreshRetry = time.Hour
+
+// nextChange returns the earliest time any monitor is due a change
+func (a *App) nextChange(expiry time.Duration) (next time.Time) {
+	for _, monitor := range a.GetMonitors() {
+		app, err := a.monitorApp(monitor)
+		if err != nil {
+			continue
+		}
+		change := app.position(monitor.Name).nextChange(expiry)
+		if next.IsZero() || change.Before(next) {
+			next = change
+		}
+	}
+	return
+}
+
+func (a *App) nextRefresh() time.Time {
+	return a.cacheTimestamp.Add(a.CacheTime)
+}
+
+func (a *App) refreshImages() error {
+	fmt.Println("Refreshing list of images in", a.folderName)
+	if err := a.LoadImages(); err != nil {
+		fmt.Println("Failed to refresh images: ", err)
+		a.retryRefresh()
+		return err
+	}
+	if err := a.SaveImages(); err != nil {
+		fmt.Println("Failed to save cache of images: ", err)
+	}
+	fmt.Println("Loaded", a.CountImages(), "images")
+	return nil
+}
+
+// retryRefresh makes the next refresh due in refreshRetry, or CacheTime if that is sooner
+func (a *App) retryRefresh() {
+	retry := refreshRetry
+	if a.CacheTime < retry {
+		retry = a.CacheTime
+	}
+	a.cacheTimestamp = time.Now().Add(retry - a.CacheTime)
+}
+
+// RefreshImages reloads the list of images in every folder in use from Imgur now
+func (a *App) RefreshImages() error {
+	if a.Offline() {
+		return fmt.Errorf("cannot refresh images while offline")
+	}
+	for _, app := range a.allApps() {
+		app.cacheTimestamp = time.Time{}
+		if err := app.refreshImages(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunDaemon keeps changing the background every expiry until a signal is received
+// on stop. The list of images is refreshed from Imgur whenever CacheTime passes.
+// Folders are switched within daemonMaxSleep of a Schedule rule starting or ending,
+// or at sunrise and sunset for rules which depend on the sun.
+// The daemon can be controlled through the socket at ControlSocket, or the web UI
+// and JSON API at http://localhost:AuthPort.
+// LoadImages must have been called first.
+func (a *App) RunDaemon(expiry time.Duration, stop <-chan os.Signal) error {
+	listener, err := a.listenControl()
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	calls := make(chan controlCall)
+	go serveControl(listener, calls)
+	a.serveWeb(calls)
+	if err = a.serveApi(calls); err != nil {
+		return err
+	}
+	if a.ShareCurrent {
+		a.serveCurrent(calls)
+	}
+	go a.runServer()
+	defer a.stopServer()
+
+	// Always set the background on startup, it may have been changed while we weren't running.
+	// A monitor which fails is tried again in the loop rather than stopping the daemon.
+	if err = a.ChangeBackground(expiry); err != nil {
+		fmt.Println("Failed to change background: ", err)
+	}
+	a.StartPrefetch()
+	folders := a.scheduledFolders(time.Now())
+
+	for {
+		now := time.Now()
+		next := a.nextChange(expiry)
+		for _, app := range a.allApps() {
+			if app.CacheTime > 0 && app.nextRefresh().Before(next) {
+				next = app.nextRefresh()
+			}
+		}
+		// Wake up at sunrise and sunset to switch folders on time
+		if sun := a.nextSunEvent(now); !sun.IsZero() && sun.Before(next) {
+			next = sun
+		}
+
+		sleep := next.Sub(now)
+		if sleep > daemonMaxSleep {
+			sleep = daemonMaxSleep
+		} else if sleep < daemonMinSleep {
+			sleep = daemonMinSleep
+		}
+
+		timer := time.NewTimer(sleep)
+		select {
+		case sig := <-stop:
+			timer.Stop()
+			fmt.Println("Received", sig, "shutting down")
+			for _, app := range a.allApps() {
+				if err = app.SaveImages(); err != nil {
+					return err
+				}
+			}
+			return nil
+		case call := <-calls:
+			timer.Stop()
+			call.response <- a.HandleControl(call.request, expiry)
+			a.StartPrefetch()
+			continue
+		case <-timer.C:
+		}
+
+		if a.Offline() {
+			if err = a.Reconnect(); err != nil {
+				fmt.Println("Still offline: ", err)
+			}
+		}
+
+		now = time.Now()
+		for _, app := range a.allApps() {
+			if app.CacheTime > 0 && !app.nextRefresh().After(now) && !app.Offline() {
+				_ = app.refreshImages()
+			}
+		}
+
+		// Show the new folder straight away when the schedule switches
+		switched := scheduleSwitched(folders, a.scheduledFolders(now))
+		if switched {
+			fmt.Println("Schedule switched folders")
+			folders = a.scheduledFolders(now)
+		}
+
+		if !switched && a.nextChange(expiry).After(now) {
+			continue
+		}
+
+		// Another machine may have changed the background since we last checked
+		for _, app := range a.allApps() {
+			if !app.Sync {
+				continue
+			}
+			if err = app.SyncState(); err != nil {
+				fmt.Println("Failed to sync state: ", err)
+			}
+		}
+
+		if err = a.ChangeBackground(expiry); err != nil {
+			fmt.Println("Failed to change background: ", err)
+		}
+		a.StartPrefetch()
+	}
+}
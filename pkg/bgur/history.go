@@ -0,0 +1,148 @@
+package bgur
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/m1cr0man/bgur/pkg/imgur"
+)
+
+const HistoryFileName = "history.jsonl"
+
+// HistoryEntry records a background being shown. The history file has one entry per line
+type HistoryEntry struct {
+	Time    string `json:"time"`
+	ImageId string `json:"image_id"`
+	Link    string `json:"link"`
+	Title   string `json:"title,omitempty"`
+	Folder  string `json:"folder,omitempty"`
+	Monitor string `json:"monitor,omitempty"`
+	Machine string `json:"machine,omitempty"`
+}
+
+// Matches checks if the query appears in the id, link, title, folder or monitor
+func (e HistoryEntry) Matches(query string) bool {
+	query = strings.ToLower(query)
+	for _, field := range []string{e.ImageId, e.Link, e.Title, e.Folder, e.Monitor} {
+		if strings.Contains(strings.ToLower(field), query) {
+			return true
+		}
+	}
+	return false
+}
+
+// HistoryFile returns the history of the account, so that one account can't show another's images
+func HistoryFile(configDir, account string) string {
+	return filepath.Join(configDir, AccountFileName(account, HistoryFileName))
+}
+
+func (a *App) recordHistory(monitor Monitor, image imgur.Image) error {
+	machine, _ := os.Hostname()
+	entry, err := json.Marshal(HistoryEntry{
+		Time:    time.Now().Format(TimeFormat),
+		ImageId: image.Id,
+		Link:    image.Link,
+		Title:   image.Title,
+		Folder:  a.folderName,
+		Monitor: monitor.Name,
+		Machine: machine,
+	})
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(HistoryFile(a.ConfigDir, a.account()), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(append(entry, '\n'))
+	if err2 := file.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// ReadHistory loads every history entry, oldest first
+func ReadHistory(configDir, account string) (entries []HistoryEntry, err error) {
+	file, err := os.Open(HistoryFile(configDir, account))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry HistoryEntry
+		// Skip lines that were partially written
+		if json.Unmarshal(scanner.Bytes(), &entry) == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// findHistoryImage looks up an image that was shown before
+func (a *App) findHistoryImage(imageId string) (image imgur.Image, err error) {
+	entries, err := ReadHistory(a.ConfigDir, a.account())
+	if err != nil {
+		return
+	}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].ImageId == imageId {
+			image.Id = entries[i].ImageId
+			image.Link = entries[i].Link
+			image.Title = entries[i].Title
+			return
+		}
+	}
+	return image, fmt.Errorf("image %s is not in the history", imageId)
+}
+
+// ShowImage sets a specific image as the background of the monitors.
+// If the image is in the folder of a monitor, the rotation continues from there.
+func (a *App) ShowImage(monitors []Monitor, imageId string) error {
+	apps := make(map[*App]bool)
+
+	for _, monitor := range monitors {
+		app, err := a.monitorApp(monitor)
+		if err != nil {
+			return err
+		}
+
+		var image imgur.Image
+		found := false
+		for pos := range app.images {
+			if app.images[pos].Id == imageId {
+				image = app.selectImage(monitor, pos)
+				found = true
+				break
+			}
+		}
+
+		// It may have been removed from the folder since. Show it without moving
+		if !found {
+			if image, err = a.findHistoryImage(imageId); err != nil {
+				return err
+			}
+		}
+
+		if err = app.applyImage(monitor, image); err != nil {
+			return err
+		}
+		if err = app.recordHistory(monitor, image); err != nil {
+			fmt.Println("Failed to record history: ", err)
+		}
+		apps[app] = true
+	}
+
+	return saveApps(apps)
+}
@@ -0,0 +1,213 @@
+package bgur
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/m1cr0man/bgur/pkg/imgur"
+	"github.com/reujab/wallpaper"
+)
+
+// Monitor is a screen which gets its own background
+type Monitor struct {
+	// Name is used to track the position of this monitor in the state
+	Name string `json:"name"`
+	// Folder to pull backgrounds from. Defaults to the selected folder
+	Folder    string `json:"folder,omitempty"`
+	MinRatio  int    `json:"min_ratio,omitempty"`
+	MaxRatio  int    `json:"max_ratio,omitempty"`
+	MinWidth  int    `json:"min_width,omitempty"`
+	MinHeight int    `json:"min_height,omitempty"`
+	// Resolution to resize images to. Images are used as they are if unset
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+	// How to fit images to the resolution. See FitCrop, FitContain and FitBlur
+	Fit string `json:"fit,omitempty"`
+	// Use images of any ratio, letterboxing them with Fit. Requires a resolution and
+	// a Fit other than FitCrop
+	AcceptAnyRatio bool `json:"accept_any_ratio,omitempty"`
+	// Command which sets the background on this monitor. {file} is replaced with
+	// the path to the image. If empty, the background is set on all monitors.
+	Command []string `json:"command,omitempty"`
+}
+
+// Accepts checks if the image fits the ratio and resolution of the monitor
+func (m Monitor) Accepts(image imgur.Image) bool {
+	if m.letterboxes() {
+		return image.Width >= m.MinWidth && image.Height >= m.MinHeight
+	}
+	if (m.MinRatio > 0 && image.Ratio() < m.MinRatio) || (m.MaxRatio > 0 && image.Ratio() > m.MaxRatio) {
+		return false
+	}
+	return image.Width >= m.MinWidth && image.Height >= m.MinHeight
+}
+
+// letterboxes checks if images of the wrong ratio can be fitted to the monitor
+func (m Monitor) letterboxes() bool {
+	return m.AcceptAnyRatio && m.resizes() && m.fit() != FitCrop
+}
+
+func (m Monitor) SetBackground(imagePath string) error {
+	if len(m.Command) == 0 {
+		return wallpaper.SetFromFile(imagePath)
+	}
+
+	args := make([]string, len(m.Command))
+	for i, arg := range m.Command {
+		args[i] = strings.Replace(arg, "{file}", imagePath, -1)
+	}
+
+	output, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s failed: %s. Output: %s", args[0], err, output)
+	}
+	return nil
+}
+
+// position tracks the background of one monitor
+type position struct {
+	currentImage int
+	dateChanged  time.Time
+	pinnedUntil  time.Time
+}
+
+func (p *position) nextChange(expiry time.Duration) time.Time {
+	next := p.dateChanged.Add(expiry)
+	if p.pinnedUntil.After(next) {
+		return p.pinnedUntil
+	}
+	return next
+}
+
+// expire makes the background due to change, even if it was pinned
+func (p *position) expire() {
+	p.dateChanged = time.Time{}
+	p.pinnedUntil = time.Time{}
+}
+
+// position returns the position of a monitor. The unnamed monitor uses the
+// top level state so that state from older versions still works.
+func (a *App) position(monitor string) *position {
+	if monitor == "" {
+		return &a.parsedState.position
+	}
+
+	if a.monitorPositions == nil {
+		a.monitorPositions = make(map[string]*position)
+	}
+	pos, found := a.monitorPositions[monitor]
+	if !found {
+		pos = &position{}
+		a.monitorPositions[monitor] = pos
+	}
+	return pos
+}
+
+func (a *App) positions() []*position {
+	positions := []*position{&a.parsedState.position}
+	for _, pos := range a.monitorPositions {
+		positions = append(positions, pos)
+	}
+	return positions
+}
+
+// GetMonitors returns the configured monitors, or a single monitor covering all screens
+func (a *App) GetMonitors() []Monitor {
+	if len(a.Monitors) == 0 {
+		return []Monitor{{}}
+	}
+	return a.Monitors
+}
+
+// GetMonitor finds a monitor by name
+func (a *App) GetMonitor(name string) (monitor Monitor, err error) {
+	for _, monitor = range a.GetMonitors() {
+		if monitor.Name == name {
+			return
+		}
+	}
+	return monitor, fmt.Errorf("no monitor called %s", name)
+}
+
+// folderApp returns the App for another folder owned by the same user.
+// It shares the API client and loads its own state and images.
+func (a *App) folderApp(folderName string) (*App, error) {
+	if folderName == "" || strings.ToLower(folderName) == strings.ToLower(a.folderName) {
+		return a, nil
+	}
+
+	key := strings.ToLower(folderName)
+	if app, found := a.folderApps[key]; found {
+		return app, nil
+	}
+
+	app := &App{
+		ConfigDir: a.ConfigDir,
+		CacheDir:  a.CacheDir,
+		CacheTime: a.CacheTime,
+		Sync:      a.Sync,
+		Account:   a.Account,
+		api:       a.api,
+		conn:      a.conn,
+	}
+	// New folders shuffle with the same seed. Existing state will override it
+	app.seed = a.seed
+
+	if err := app.SelectFolder(a.folderOwner, folderName); err != nil {
+		return nil, err
+	}
+
+	if err := app.LoadState(); err != nil {
+		fmt.Println("Failed to load state for", folderName, ": ", err)
+	}
+
+	if app.Sync {
+		if err := app.SyncState(); err != nil {
+			fmt.Println("Failed to sync state for", folderName, ": ", err)
+		}
+	}
+
+	// Bans apply to every folder, including ones loaded after the ban
+	if app.mergeBans(parsedState{banned: a.BannedImages()}) {
+		if err := app.SaveState(); err != nil {
+			fmt.Println("Failed to save bans for", folderName, ": ", err)
+		}
+	}
+
+	if err := app.LoadImages(); err != nil {
+		return nil, err
+	}
+
+	if a.folderApps == nil {
+		a.folderApps = make(map[string]*App)
+	}
+	a.folderApps[key] = app
+	return app, nil
+}
+
+// monitorApp returns the App which holds the images for the monitor.
+// Each folder has its own App, so the position in each folder is kept when the schedule switches.
+func (a *App) monitorApp(monitor Monitor) (*App, error) {
+	return a.folderApp(a.scheduledFolder(monitor, time.Now()))
+}
+
+// monitorApps loads the App of every monitor and returns all of them
+func (a *App) monitorApps() []*App {
+	for _, monitor := range a.GetMonitors() {
+		if _, err := a.monitorApp(monitor); err != nil {
+			fmt.Println("Failed to load folder for monitor", monitor.Name, ": ", err)
+		}
+	}
+	return a.allApps()
+}
+
+// allApps returns this App and the Apps of every other folder in use
+func (a *App) allApps() []*App {
+	apps := []*App{a}
+	for _, app := range a.folderApps {
+		apps = append(apps, app)
+	}
+	return apps
+}
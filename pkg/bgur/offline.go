@@ -0,0 +1,113 @@
+package bgur
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/m1cr0man/bgur/pkg/imgur"
+)
+
+// connection tracks whether Imgur can be reached. It is shared between the Apps of every folder
+type connection struct {
+	offline bool
+}
+
+func (a *App) foldersCacheFile(folderOwner string) string {
+	return a.accountFile(a.CacheDir, fmt.Sprintf("folders.%s.json", folderOwner))
+}
+
+// Offline checks if bgur is running from the cache only
+func (a *App) Offline() bool {
+	return a.conn.offline
+}
+
+// SetOffline stops bgur from talking to Imgur until Reconnect succeeds
+func (a *App) SetOffline(reason error) {
+	if !a.conn.offline {
+		fmt.Println("Imgur is unavailable, working offline: ", reason)
+	}
+	a.conn.offline = true
+}
+
+// checkOffline switches to offline mode if err was caused by Imgur being unavailable
+func (a *App) checkOffline(err error) bool {
+	if imgur.IsUnavailable(err) {
+		a.SetOffline(err)
+		return true
+	}
+	return false
+}
+
+// Reconnect tries to reach Imgur again, pushing any state saved while offline
+func (a *App) Reconnect() (err error) {
+	if !a.Offline() {
+		return
+	}
+
+	// Authorisation failed at startup. Don't retry if we never had a token, it would need a browser
+	if a.api.Client == nil {
+		if _, err = a.api.AuthFromStore(); err != nil {
+			return
+		}
+		if err = a.Authorise(); err != nil {
+			return
+		}
+	}
+
+	if _, err = a.api.GetFolders(a.folderOwner); err != nil {
+		return
+	}
+
+	a.conn.offline = false
+	fmt.Println("Imgur is available again")
+
+	for _, app := range a.allApps() {
+		if err = app.SyncState(); err != nil {
+			fmt.Println("Failed to sync state: ", err)
+		}
+	}
+	return nil
+}
+
+// getFolders lists the folders of folderOwner, falling back to the cached list when offline
+func (a *App) getFolders(folderOwner string) (folders []imgur.Folder, err error) {
+	if !a.Offline() {
+		folders, err = a.api.GetFolders(folderOwner)
+		if err == nil {
+			if err2 := a.saveJSON(a.foldersCacheFile(folderOwner), folders); err2 != nil {
+				fmt.Println("Failed to save cache of folders: ", err2)
+			}
+			return
+		}
+		if !a.checkOffline(err) {
+			return
+		}
+	}
+
+	data, err2 := ioutil.ReadFile(a.foldersCacheFile(folderOwner))
+	if err2 != nil {
+		if err == nil {
+			err = fmt.Errorf("no cached folders for %s available offline", folderOwner)
+		}
+		return
+	}
+	err = json.Unmarshal(data, &folders)
+	return
+}
+
+// isDerived checks if the image has already been resized for the monitor
+func (a *App) isDerived(image imgur.Image, monitor Monitor) bool {
+	if !monitor.resizes() {
+		return false
+	}
+	_, err := os.Stat(a.derivedFile(image, monitor))
+	return err == nil
+}
+
+// isDownloaded checks if the image is in the cache, so that it can be used offline
+func (a *App) isDownloaded(image imgur.Image) bool {
+	_, err := os.Stat(a.imageFile(image))
+	return err == nil
+}
@@ -0,0 +1,114 @@
+package bgur
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/m1cr0man/bgur/pkg/imgur"
+)
+
+const DefaultPrefetchConcurrency = 2
+
+// prefetcher stops more than one round of prefetching running at a time
+type prefetcher struct {
+	mu      sync.Mutex
+	running bool
+}
+
+type prefetchJob struct {
+	link string
+	path string
+}
+
+// upcoming predicts the next count images PickImage will choose for the monitor.
+// The order of the images is fixed by the seed, so this is what PickImage will choose.
+func (a *App) upcoming(monitor Monitor, count int) (images []imgur.Image) {
+	pos := a.position(monitor.Name).currentImage
+	for i := 0; i < count; i++ {
+		next, found := a.findImage(pos, 1, monitor)
+		if !found {
+			break
+		}
+		pos = next
+		images = append(images, a.images[pos])
+	}
+	return
+}
+
+// upcomingImages predicts the next Prefetch images of every monitor
+func (a *App) upcomingImages() (files map[string]imgur.Image) {
+	files = make(map[string]imgur.Image)
+	for _, monitor := range a.GetMonitors() {
+		app, err := a.monitorApp(monitor)
+		if err != nil {
+			continue
+		}
+
+		for _, image := range app.upcoming(monitor, a.Prefetch) {
+			files[app.imageFile(image)] = image
+		}
+	}
+	return
+}
+
+// StartPrefetch downloads the upcoming images in the background, so that changes
+// are instant and work offline. The returned channel is closed once it finishes.
+func (a *App) StartPrefetch() <-chan struct{} {
+	done := make(chan struct{})
+
+	a.prefetcher.mu.Lock()
+	if a.Prefetch <= 0 || a.Offline() || a.prefetcher.running {
+		a.prefetcher.mu.Unlock()
+		close(done)
+		return done
+	}
+	a.prefetcher.running = true
+	a.prefetcher.mu.Unlock()
+
+	// Work out what to download here. The App must not be touched by the download goroutines
+	var jobs []prefetchJob
+	for path, image := range a.upcomingImages() {
+		if !a.isDownloaded(image) {
+			jobs = append(jobs, prefetchJob{image.Link, path})
+		}
+	}
+
+	concurrency := a.PrefetchConcurrency
+	if concurrency < 1 {
+		concurrency = DefaultPrefetchConcurrency
+	}
+
+	go func() {
+		defer close(done)
+		defer func() {
+			a.prefetcher.mu.Lock()
+			a.prefetcher.running = false
+			a.prefetcher.mu.Unlock()
+		}()
+
+		var wg sync.WaitGroup
+		slots := make(chan struct{}, concurrency)
+		for _, job := range jobs {
+			wg.Add(1)
+			slots <- struct{}{}
+			go func(job prefetchJob) {
+				defer wg.Done()
+				defer func() { <-slots }()
+
+				data, err := a.api.DownloadImage(job.link)
+				if err == nil {
+					err = writeFileAtomic(job.path, data)
+				}
+				if err != nil {
+					fmt.Println("Failed to prefetch", job.link, ": ", err)
+					return
+				}
+				fmt.Println("Prefetched", filepath.Base(job.path))
+			}(job)
+		}
+		wg.Wait()
+	}()
+
+	return done
+}
@@ -0,0 +1,353 @@
+package bgur
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	imgLib "image"
+	"image/draw"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/m1cr0man/bgur/pkg/imgur"
+)
+
+// How images are fitted to the resolution of a monitor
+const (
+	// Scale to cover the screen and cut off the edges. This is the default
+	FitCrop = "crop"
+	// Scale to fit inside the screen, leaving black bars
+	FitContain = "fit"
+	// Scale to fit inside the screen, filling the gaps with a blurred copy of the image
+	FitBlur = "blur"
+)
+
+const resizeQuality = 90
+
+// The blurred background is made at 1/blurScale of the resolution, then scaled up
+const blurScale = 16
+const blurRadius = 2
+const blurPasses = 3
+
+var errEmptyImage = errors.New("can't resize an empty image")
+
+func (m Monitor) resizes() bool {
+	return m.Width > 0 && m.Height > 0
+}
+
+func (m Monitor) fit() string {
+	if m.Fit == "" {
+		return FitCrop
+	}
+	return m.Fit
+}
+
+// derivedFile is where the image is cached after being fitted to the monitor
+func (a *App) derivedFile(image imgur.Image, monitor Monitor) string {
+	name := filepath.Base(image.Link)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	return filepath.Join(a.CacheDir, fmt.Sprintf("%s.%dx%d-%s.jpg", name, monitor.Width, monitor.Height, monitor.fit()))
+}
+
+// backgroundFile returns the file to use as the background on the monitor,
+// downloading and resizing the image if necessary
+func (a *App) backgroundFile(image imgur.Image, monitor Monitor) (imagePath string, err error) {
+	if monitor.resizes() {
+		derived := a.derivedFile(image, monitor)
+		if _, err = os.Stat(derived); err == nil {
+			return derived, nil
+		}
+	}
+
+	imagePath, err = a.DownloadImage(image)
+	if err != nil || !monitor.resizes() {
+		return
+	}
+
+	derived, err := a.fitImage(imagePath, image, monitor)
+	if err != nil {
+		// The original is better than nothing
+		fmt.Println("Failed to resize image: ", err)
+		return imagePath, nil
+	}
+	return derived, nil
+}
+
+// fitImage resizes the image at imagePath to the resolution of the monitor and caches it
+func (a *App) fitImage(imagePath string, image imgur.Image, monitor Monitor) (string, error) {
+	encoded, err := resizeImage(imagePath, monitor)
+	if err != nil {
+		return "", err
+	}
+
+	derived := a.derivedFile(image, monitor)
+	return derived, writeFileAtomic(derived, encoded)
+}
+
+// resizeImage returns the image at imagePath as a JPEG fitted to the resolution of the monitor
+func resizeImage(imagePath string, monitor Monitor) ([]byte, error) {
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	src, _, err := imgLib.Decode(file)
+	if err != nil {
+		return nil, err
+	}
+	if src.Bounds().Empty() {
+		return nil, errEmptyImage
+	}
+
+	var result imgLib.Image
+	switch monitor.fit() {
+	case FitCrop:
+		result, err = cropImage(src, monitor.Width, monitor.Height)
+	case FitContain:
+		result, err = containImage(src, monitor.Width, monitor.Height)
+	case FitBlur:
+		result, err = blurFillImage(src, monitor.Width, monitor.Height)
+	default:
+		return nil, fmt.Errorf("unknown fit %s for monitor %s", monitor.Fit, monitor.Name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	encoded := &bytes.Buffer{}
+	if err = jpeg.Encode(encoded, result, &jpeg.Options{Quality: resizeQuality}); err != nil {
+		return nil, err
+	}
+	return encoded.Bytes(), nil
+}
+
+// toRGBA converts the image to RGBA so that the pixels can be read directly
+func toRGBA(src imgLib.Image) *imgLib.RGBA {
+	if rgba, ok := src.(*imgLib.RGBA); ok {
+		return rgba
+	}
+	bounds := src.Bounds()
+	rgba := imgLib.NewRGBA(imgLib.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+	return rgba
+}
+
+// cropImage scales the image to cover width x height, cutting off the edges evenly
+func cropImage(src imgLib.Image, width, height int) (*imgLib.RGBA, error) {
+	rgba := toRGBA(src)
+	srcWidth, srcHeight := rgba.Bounds().Dx(), rgba.Bounds().Dy()
+
+	// Find the largest area in the middle of the source with the target ratio
+	cropWidth, cropHeight := srcWidth, srcHeight
+	if srcWidth*height > srcHeight*width {
+		cropWidth = srcHeight * width / height
+	} else {
+		cropHeight = srcWidth * height / width
+	}
+	// Very wide or tall targets round down to nothing
+	if cropWidth < 1 {
+		cropWidth = 1
+	}
+	if cropHeight < 1 {
+		cropHeight = 1
+	}
+
+	left := rgba.Bounds().Min.X + (srcWidth-cropWidth)/2
+	top := rgba.Bounds().Min.Y + (srcHeight-cropHeight)/2
+	cropped := rgba.SubImage(imgLib.Rect(left, top, left+cropWidth, top+cropHeight)).(*imgLib.RGBA)
+
+	return scaleImage(cropped, width, height)
+}
+
+// containImage scales the image to fit inside width x height, centred on a black background
+func containImage(src imgLib.Image, width, height int) (*imgLib.RGBA, error) {
+	canvas := imgLib.NewRGBA(imgLib.Rect(0, 0, width, height))
+	draw.Draw(canvas, canvas.Bounds(), imgLib.Black, imgLib.Point{}, draw.Src)
+	return canvas, drawCentred(canvas, src)
+}
+
+// blurFillImage scales the image to fit inside width x height, centred over a
+// blurred copy of itself which covers the whole screen
+func blurFillImage(src imgLib.Image, width, height int) (*imgLib.RGBA, error) {
+	smallWidth, smallHeight := width/blurScale, height/blurScale
+	if smallWidth < 1 {
+		smallWidth = 1
+	}
+	if smallHeight < 1 {
+		smallHeight = 1
+	}
+
+	// Blurring a small copy and scaling it up is much faster than blurring at full size
+	background, err := cropImage(src, smallWidth, smallHeight)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < blurPasses; i++ {
+		background = boxBlur(background, blurRadius)
+	}
+
+	canvas, err := scaleImage(background, width, height)
+	if err != nil {
+		return nil, err
+	}
+	return canvas, drawCentred(canvas, src)
+}
+
+// boxBlur averages each pixel with its neighbours within radius
+func boxBlur(src *imgLib.RGBA, radius int) *imgLib.RGBA {
+	bounds := src.Bounds()
+	dst := imgLib.NewRGBA(bounds)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			var sum [4]int
+			var count int
+			for dy := -radius; dy <= radius; dy++ {
+				for dx := -radius; dx <= radius; dx++ {
+					p := imgLib.Pt(x+dx, y+dy)
+					if !p.In(bounds) {
+						continue
+					}
+					offset := src.PixOffset(p.X, p.Y)
+					for c := 0; c < 4; c++ {
+						sum[c] += int(src.Pix[offset+c])
+					}
+					count++
+				}
+			}
+
+			out := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				dst.Pix[out+c] = uint8(sum[c] / count)
+			}
+		}
+	}
+	return dst
+}
+
+// drawCentred scales src to fit inside dst and draws it in the middle
+func drawCentred(dst *imgLib.RGBA, src imgLib.Image) error {
+	width, height := dst.Bounds().Dx(), dst.Bounds().Dy()
+	srcWidth, srcHeight := src.Bounds().Dx(), src.Bounds().Dy()
+
+	fitWidth, fitHeight := width, height
+	if srcWidth*height > srcHeight*width {
+		fitHeight = srcHeight * width / srcWidth
+	} else {
+		fitWidth = srcWidth * height / srcHeight
+	}
+	if fitWidth < 1 {
+		fitWidth = 1
+	}
+	if fitHeight < 1 {
+		fitHeight = 1
+	}
+
+	scaled, err := scaleImage(toRGBA(src), fitWidth, fitHeight)
+	if err != nil {
+		return err
+	}
+	offset := imgLib.Pt((width-fitWidth)/2, (height-fitHeight)/2)
+	draw.Draw(dst, scaled.Bounds().Add(offset), scaled, imgLib.Point{}, draw.Src)
+	return nil
+}
+
+// scaleImage resizes src to width x height. Shrinking averages every source pixel
+// covered by an output pixel, growing interpolates between the nearest four.
+func scaleImage(src *imgLib.RGBA, width, height int) (*imgLib.RGBA, error) {
+	if src.Bounds().Empty() || width < 1 || height < 1 {
+		return nil, errEmptyImage
+	}
+	if src.Bounds().Dx() > width || src.Bounds().Dy() > height {
+		return shrinkImage(src, width, height), nil
+	}
+	return growImage(src, width, height), nil
+}
+
+func shrinkImage(src *imgLib.RGBA, width, height int) *imgLib.RGBA {
+	dst := imgLib.NewRGBA(imgLib.Rect(0, 0, width, height))
+	bounds := src.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+
+	for y := 0; y < height; y++ {
+		y0 := y * srcHeight / height
+		y1 := (y + 1) * srcHeight / height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+
+		for x := 0; x < width; x++ {
+			x0 := x * srcWidth / width
+			x1 := (x + 1) * srcWidth / width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.PixOffset(bounds.Min.X+x0, bounds.Min.Y+sy)
+				for sx := x0; sx < x1; sx++ {
+					for c := 0; c < 4; c++ {
+						sum[c] += int(src.Pix[row+c])
+					}
+					row += 4
+				}
+			}
+
+			count := (y1 - y0) * (x1 - x0)
+			out := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				dst.Pix[out+c] = uint8(sum[c] / count)
+			}
+		}
+	}
+	return dst
+}
+
+func growImage(src *imgLib.RGBA, width, height int) *imgLib.RGBA {
+	dst := imgLib.NewRGBA(imgLib.Rect(0, 0, width, height))
+	bounds := src.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+
+	for y := 0; y < height; y++ {
+		// Position of the output pixel centre in the source, in 1/256ths of a pixel
+		sy := ((2*y+1)*srcHeight*256/height - 256) / 2
+		if sy < 0 {
+			sy = 0
+		}
+		y0 := sy / 256
+		y1 := y0 + 1
+		if y1 >= srcHeight {
+			y1 = srcHeight - 1
+		}
+		fy := sy % 256
+
+		for x := 0; x < width; x++ {
+			sx := ((2*x+1)*srcWidth*256/width - 256) / 2
+			if sx < 0 {
+				sx = 0
+			}
+			x0 := sx / 256
+			x1 := x0 + 1
+			if x1 >= srcWidth {
+				x1 = srcWidth - 1
+			}
+			fx := sx % 256
+
+			p00 := src.PixOffset(bounds.Min.X+x0, bounds.Min.Y+y0)
+			p01 := src.PixOffset(bounds.Min.X+x1, bounds.Min.Y+y0)
+			p10 := src.PixOffset(bounds.Min.X+x0, bounds.Min.Y+y1)
+			p11 := src.PixOffset(bounds.Min.X+x1, bounds.Min.Y+y1)
+			out := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				top := int(src.Pix[p00+c])*(256-fx) + int(src.Pix[p01+c])*fx
+				bottom := int(src.Pix[p10+c])*(256-fx) + int(src.Pix[p11+c])*fx
+				dst.Pix[out+c] = uint8((top*(256-fy) + bottom*fy) / (256 * 256))
+			}
+		}
+	}
+	return dst
+}
@@ -0,0 +1,210 @@
+package bgur
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const scheduleTimeFormat = "15:04"
+const scheduleDateFormat = "01-02"
+
+// ScheduleRule switches to another folder at certain times. Every condition
+// which is set must match. The first matching rule in the schedule is used.
+type ScheduleRule struct {
+	// Folder to pull backgrounds from while the rule matches
+	Folder string `json:"folder"`
+	// Time window in 24 hour HH:MM format. It can wrap past midnight, such as 20:00 to 07:00
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+	// Days of the week, such as ["saturday", "sunday"] or ["sat", "sun"]
+	Weekdays []string `json:"weekdays,omitempty"`
+	// Date range in MM-DD format, including both ends. It can wrap past new year
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+	// SunDay between sunrise and sunset, SunNight otherwise. Requires a Location
+	Sun string `json:"sun,omitempty"`
+	// Names of the monitors the rule applies to. Defaults to monitors without their own folder
+	Monitors []string `json:"monitors,omitempty"`
+}
+
+// minuteOfDay parses a HH:MM time
+func minuteOfDay(value string) (int, error) {
+	parsed, err := time.Parse(scheduleTimeFormat, value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %s, expected HH:MM", value)
+	}
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
+
+// dayOfYear parses a MM-DD date into a number which sorts by date
+func dayOfYear(value string) (int, error) {
+	parsed, err := time.Parse(scheduleDateFormat, value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid date %s, expected MM-DD", value)
+	}
+	return int(parsed.Month())*100 + parsed.Day(), nil
+}
+
+func parseWeekday(value string) (time.Weekday, error) {
+	value = strings.ToLower(value)
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		name := strings.ToLower(day.String())
+		if value == name || value == name[:3] {
+			return day, nil
+		}
+	}
+	return time.Sunday, fmt.Errorf("invalid weekday %s", value)
+}
+
+// Validate checks that the rule has a folder and that every condition can be parsed
+func (r ScheduleRule) Validate(location *Location) error {
+	if r.Folder == "" {
+		return fmt.Errorf("no folder set")
+	}
+	if r.Sun != "" && r.Sun != SunDay && r.Sun != SunNight {
+		return fmt.Errorf("invalid sun %s, expected %s or %s", r.Sun, SunDay, SunNight)
+	}
+	if r.Sun != "" && location == nil {
+		return fmt.Errorf("a location is needed to work out sunrise and sunset")
+	}
+	for _, value := range []string{r.Start, r.End} {
+		if _, err := minuteOfDay(value); value != "" && err != nil {
+			return err
+		}
+	}
+	for _, value := range []string{r.From, r.To} {
+		if _, err := dayOfYear(value); value != "" && err != nil {
+			return err
+		}
+	}
+	for _, value := range r.Weekdays {
+		if _, err := parseWeekday(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r ScheduleRule) appliesTo(monitor Monitor) bool {
+	if len(r.Monitors) == 0 {
+		return monitor.Folder == ""
+	}
+	for _, name := range r.Monitors {
+		if name == monitor.Name {
+			return true
+		}
+	}
+	return false
+}
+
+func (r ScheduleRule) matchesTime(now time.Time) bool {
+	if r.Start == "" && r.End == "" {
+		return true
+	}
+
+	start, end := 0, 24*60
+	if r.Start != "" {
+		start, _ = minuteOfDay(r.Start)
+	}
+	if r.End != "" {
+		end, _ = minuteOfDay(r.End)
+	}
+
+	minute := now.Hour()*60 + now.Minute()
+	if start <= end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+func (r ScheduleRule) matchesWeekday(now time.Time) bool {
+	if len(r.Weekdays) == 0 {
+		return true
+	}
+	for _, value := range r.Weekdays {
+		if day, err := parseWeekday(value); err == nil && day == now.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+func (r ScheduleRule) matchesDate(now time.Time) bool {
+	if r.From == "" && r.To == "" {
+		return true
+	}
+
+	from, to := 101, 1231
+	if r.From != "" {
+		from, _ = dayOfYear(r.From)
+	}
+	if r.To != "" {
+		to, _ = dayOfYear(r.To)
+	}
+
+	day := int(now.Month())*100 + now.Day()
+	if from <= to {
+		return day >= from && day <= to
+	}
+	return day >= from || day <= to
+}
+
+func (r ScheduleRule) matchesSun(now time.Time, location *Location) bool {
+	if r.Sun == "" {
+		return true
+	}
+	if location == nil {
+		return false
+	}
+	return location.IsDaytime(now) == (r.Sun == SunDay)
+}
+
+// Matches checks if the rule selects the folder of the monitor at now
+func (r ScheduleRule) Matches(monitor Monitor, now time.Time, location *Location) bool {
+	return r.appliesTo(monitor) && r.matchesDate(now) && r.matchesWeekday(now) && r.matchesTime(now) &&
+		r.matchesSun(now, location)
+}
+
+// scheduledFolder returns the folder the monitor should use at now.
+// An empty folder means the selected folder.
+func (a *App) scheduledFolder(monitor Monitor, now time.Time) string {
+	for _, rule := range a.Schedule {
+		if rule.Matches(monitor, now, a.Location) {
+			return rule.Folder
+		}
+	}
+	return monitor.Folder
+}
+
+// scheduledFolders returns the folder of every monitor at now, by monitor name
+func (a *App) scheduledFolders(now time.Time) map[string]string {
+	folders := make(map[string]string)
+	for _, monitor := range a.GetMonitors() {
+		folders[monitor.Name] = strings.ToLower(a.scheduledFolder(monitor, now))
+	}
+	return folders
+}
+
+// nextSunEvent returns the next sunrise or sunset if any rule depends on the sun
+func (a *App) nextSunEvent(now time.Time) time.Time {
+	if a.Location == nil {
+		return time.Time{}
+	}
+	for _, rule := range a.Schedule {
+		if rule.Sun != "" {
+			return a.Location.NextSunEvent(now)
+		}
+	}
+	return time.Time{}
+}
+
+// scheduleSwitched checks if any monitor has moved to another folder
+func scheduleSwitched(before, after map[string]string) bool {
+	for name, folder := range after {
+		if before[name] != folder {
+			return true
+		}
+	}
+	return false
+}
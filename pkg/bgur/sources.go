@@ -0,0 +1,161 @@
+package bgur
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+
+	"github.com/m1cr0man/bgur/pkg/imgur"
+)
+
+// FolderSource is one of several folders merged into a single rotation
+type FolderSource struct {
+	// User who owns the folder. Defaults to the folder owner
+	Owner string `json:"owner,omitempty"`
+	Name  string `json:"name"`
+	// Share of the rotation relative to the other folders. Defaults to 1
+	Weight int `json:"weight,omitempty"`
+}
+
+type folderSource struct {
+	FolderSource
+	id int
+}
+
+// SelectFolders merges several folders into one rotation. The first folder is
+// used for anything which needs a single folder, such as adding albums.
+func (a *App) SelectFolders(defaultOwner string, sources []FolderSource) error {
+	if len(sources) == 0 {
+		return fmt.Errorf("no folders given")
+	}
+
+	var selected []folderSource
+	var names []string
+	for _, source := range sources {
+		if source.Owner == "" {
+			source.Owner = defaultOwner
+		}
+		if source.Weight <= 0 {
+			source.Weight = 1
+		}
+		if err := a.SelectFolder(source.Owner, source.Name); err != nil {
+			return err
+		}
+
+		source.Name = a.folderName
+		selected = append(selected, folderSource{source, a.folderId})
+		names = append(names, a.folderName)
+	}
+
+	a.folderOwner = selected[0].Owner
+	a.folderId = selected[0].id
+	if len(selected) > 1 {
+		a.sources = selected
+		a.folderName = strings.Join(names, " + ")
+	}
+	return nil
+}
+
+// sourcesId identifies the combination of merged folders
+func (a *App) sourcesId() string {
+	ids := make([]string, len(a.sources))
+	for i, source := range a.sources {
+		ids[i] = fmt.Sprintf("%s.%d", source.Owner, source.id)
+	}
+	return "merged." + strings.Join(ids, "+")
+}
+
+// sourceCacheFile holds the images of one merged folder, so that it can still be
+// used if it fails to refresh
+func (a *App) sourceCacheFile(source folderSource) string {
+	return a.accountFile(a.CacheDir, fmt.Sprintf("source.%s.%d.json", source.Owner, source.id))
+}
+
+func (a *App) shuffle(images []imgur.Image) {
+	if a.seed > 0 {
+		rand.Seed(a.seed)
+		Randomise(images)
+	}
+}
+
+// fetchImages gets the images in the folder from Imgur, shuffled with the seed.
+// Merged folders are shuffled separately and then interleaved by weight.
+func (a *App) fetchImages() (images []imgur.Image, err error) {
+	if len(a.sources) == 0 {
+		images, err = a.api.GetFolderImages(a.folderOwner, a.folderId)
+		a.shuffle(images)
+		return
+	}
+
+	lists := make([][]imgur.Image, len(a.sources))
+	weights := make([]int, len(a.sources))
+	for i, source := range a.sources {
+		if lists[i], err = a.fetchSource(source); err != nil {
+			return nil, err
+		}
+		a.shuffle(lists[i])
+		weights[i] = source.Weight
+	}
+	return interleave(lists, weights), nil
+}
+
+// fetchSource gets the images in one of the merged folders. If the folder can't
+// be loaded, such as when it was deleted, the last copy is used instead.
+func (a *App) fetchSource(source folderSource) (images []imgur.Image, err error) {
+	images, err = a.api.GetFolderImages(source.Owner, source.id)
+	if err == nil {
+		if err2 := a.saveJSON(a.sourceCacheFile(source), images); err2 != nil {
+			fmt.Println("Failed to save cache of", source.Name, ": ", err2)
+		}
+		return
+	}
+
+	// LoadImages falls back to the merged list when Imgur is unavailable
+	if imgur.IsUnavailable(err) {
+		return
+	}
+
+	data, err2 := ioutil.ReadFile(a.sourceCacheFile(source))
+	if err2 != nil {
+		return nil, fmt.Errorf("failed to load images in %s: %s", source.Name, err)
+	}
+	fmt.Println("Failed to load images in", source.Name, ", using the last copy: ", err)
+	return images, json.Unmarshal(data, &images)
+}
+
+// interleave merges the lists so that each list takes up a share of the result
+// in proportion to its weight, spread out as evenly as possible. Once a list runs
+// out the others fill the rest. Images in more than one list are only used once.
+func interleave(lists [][]imgur.Image, weights []int) (merged []imgur.Image) {
+	next := make([]int, len(lists))
+	credit := make([]int, len(lists))
+	seen := make(map[string]bool)
+
+	for {
+		// Smooth weighted round robin. Every list earns its weight and the richest is picked
+		best, total := -1, 0
+		for i := range lists {
+			if next[i] >= len(lists[i]) {
+				continue
+			}
+			credit[i] += weights[i]
+			total += weights[i]
+			if best < 0 || credit[i] > credit[best] {
+				best = i
+			}
+		}
+		if best < 0 {
+			return
+		}
+		credit[best] -= total
+
+		image := lists[best][next[best]]
+		next[best]++
+		if !seen[image.Id] {
+			seen[image.Id] = true
+			merged = append(merged, image)
+		}
+	}
+}
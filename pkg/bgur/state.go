@@ -9,7 +9,6 @@ import (
 	"image/png"
 	_ "image/png"
 	"io/ioutil"
-	"path/filepath"
 	"time"
 
 	"github.com/m1cr0man/bgur/pkg/imgur"
@@ -23,28 +22,79 @@ const TimeFormat = time.RFC3339
 type State struct {
 	CurrentImage int `json:"current_image"`
 	// TODO load cacheTimestamp from cache file, remove from state
-	CacheTimestamp string `json:"cache_timestamp"`
-	DateChanged    string `json:"date_changed"`
-	StateTimestamp string `json:"state_timestamp"`
-	Seed           int64  `json:"seed"`
+	CacheTimestamp string                  `json:"cache_timestamp"`
+	DateChanged    string                  `json:"date_changed"`
+	StateTimestamp string                  `json:"state_timestamp"`
+	Seed           int64                   `json:"seed"`
+	PinnedUntil    string                  `json:"pinned_until,omitempty"`
+	Banned         []string                `json:"banned,omitempty"`
+	Monitors       map[string]MonitorState `json:"monitors,omitempty"`
+	// Only stored locally. Set when the state was saved while offline
+	PendingSync bool `json:"pending_sync,omitempty"`
+}
+
+type MonitorState struct {
+	CurrentImage int    `json:"current_image"`
+	DateChanged  string `json:"date_changed"`
+	PinnedUntil  string `json:"pinned_until,omitempty"`
 }
 
 type parsedState struct {
-	currentImage   int
-	cacheTimestamp time.Time
-	dateChanged    time.Time
-	stateTimestamp time.Time
-	seed           int64
+	position
+	cacheTimestamp   time.Time
+	stateTimestamp   time.Time
+	seed             int64
+	banned           []string
+	monitorPositions map[string]*position
+	pendingSync      bool
+}
+
+func (p *position) getState() MonitorState {
+	state := MonitorState{
+		CurrentImage: p.currentImage,
+		DateChanged:  p.dateChanged.Format(TimeFormat),
+	}
+	if !p.pinnedUntil.IsZero() {
+		state.PinnedUntil = p.pinnedUntil.Format(TimeFormat)
+	}
+	return state
+}
+
+func parseMonitorState(state MonitorState) (position position, err error) {
+	position.dateChanged, err = time.Parse(TimeFormat, state.DateChanged)
+	// Only fail if the date changed wasn't nil to begin with
+	if err != nil && state.DateChanged != "" {
+		return
+	}
+	position.pinnedUntil, err = time.Parse(TimeFormat, state.PinnedUntil)
+	if err != nil && state.PinnedUntil != "" {
+		return
+	}
+
+	position.currentImage = state.CurrentImage
+	return position, nil
 }
 
 func (a *App) getState() State {
-	return State{
-		CurrentImage:   a.currentImage,
+	position := a.position("").getState()
+	state := State{
+		CurrentImage:   position.CurrentImage,
 		CacheTimestamp: a.cacheTimestamp.Format(TimeFormat),
-		DateChanged:    a.dateChanged.Format(TimeFormat),
+		DateChanged:    position.DateChanged,
 		StateTimestamp: time.Now().Format(TimeFormat),
 		Seed:           a.seed,
+		PinnedUntil:    position.PinnedUntil,
+		Banned:         a.banned,
+		PendingSync:    a.pendingSync,
 	}
+
+	if len(a.monitorPositions) > 0 {
+		state.Monitors = make(map[string]MonitorState, len(a.monitorPositions))
+		for name, pos := range a.monitorPositions {
+			state.Monitors[name] = pos.getState()
+		}
+	}
+	return state
 }
 
 func (a *App) parseRawState(data []byte) (parsedState parsedState, err error) {
@@ -55,9 +105,12 @@ func (a *App) parseRawState(data []byte) (parsedState parsedState, err error) {
 	}
 
 	// Copy values into struct and parse them
-	parsedState.dateChanged, err = time.Parse(TimeFormat, state.DateChanged)
-	// Only fail if the date changed wasn't nil to begin with
-	if err != nil && state.DateChanged != "" {
+	parsedState.position, err = parseMonitorState(MonitorState{
+		CurrentImage: state.CurrentImage,
+		DateChanged:  state.DateChanged,
+		PinnedUntil:  state.PinnedUntil,
+	})
+	if err != nil {
 		return
 	}
 	parsedState.cacheTimestamp, err = time.Parse(TimeFormat, state.CacheTimestamp)
@@ -72,17 +125,33 @@ func (a *App) parseRawState(data []byte) (parsedState parsedState, err error) {
 	// Make sure err is nil after parsing all timestamps
 	err = nil
 
-	parsedState.currentImage = state.CurrentImage
+	if len(state.Monitors) > 0 {
+		parsedState.monitorPositions = make(map[string]*position, len(state.Monitors))
+		for name, monitorState := range state.Monitors {
+			var pos position
+			pos, err = parseMonitorState(monitorState)
+			if err != nil {
+				return
+			}
+			parsedState.monitorPositions[name] = &pos
+		}
+	}
+
 	parsedState.seed = state.Seed
+	parsedState.banned = state.Banned
+	parsedState.pendingSync = state.PendingSync
 	return
 }
 
 func (a *App) stateId() string {
+	if len(a.sources) > 0 {
+		return a.sourcesId()
+	}
 	return fmt.Sprintf("%s.%d", a.folderOwner, a.folderId)
 }
 
 func (a *App) stateFile() string {
-	return filepath.Join(a.ConfigDir, fmt.Sprintf("state.%s.json", a.stateId()))
+	return a.accountFile(a.ConfigDir, fmt.Sprintf("state.%s.json", a.stateId()))
 }
 
 func (a *App) GetStateAlbum() (album imgur.Album, err error) {
@@ -206,14 +275,80 @@ func (a *App) DownloadState() (data []byte, err error) {
 
 func (a *App) SaveState() (err error) {
 	a.stateTimestamp = time.Now()
-	state := a.getState()
-	err = a.saveJSON(a.stateFile(), state)
-	if err != nil || !a.Sync {
+
+	// Queue the sync until Imgur is available again
+	a.pendingSync = a.Sync && a.Offline()
+	err = a.saveJSON(a.stateFile(), a.getState())
+	if err != nil || !a.Sync || a.Offline() {
 		return
 	}
 
 	// Sync with imgur
-	return a.UploadState(state)
+	return a.pushState()
+}
+
+// pushState uploads the state to Imgur, queueing it if Imgur is unavailable
+func (a *App) pushState() (err error) {
+	state := a.getState()
+	state.PendingSync = false
+
+	err = a.UploadState(state)
+	if err != nil && !a.checkOffline(err) {
+		return
+	}
+
+	// Record whether the sync is still pending
+	a.pendingSync = err != nil
+	return a.saveJSON(a.stateFile(), a.getState())
+}
+
+// PushState uploads the state of every folder in use to Imgur, replacing the
+// state saved by other computers even if it is newer
+func (a *App) PushState() (err error) {
+	if a.Offline() {
+		return fmt.Errorf("cannot push state while offline")
+	}
+
+	for _, app := range a.monitorApps() {
+		state := app.getState()
+		state.PendingSync = false
+		if err = app.UploadState(state); err != nil {
+			return
+		}
+
+		app.pendingSync = false
+		if err = app.saveJSON(app.stateFile(), app.getState()); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// PullState replaces the state of every folder in use with the state on Imgur,
+// even if the local state is newer
+func (a *App) PullState() (err error) {
+	if a.Offline() {
+		return fmt.Errorf("cannot pull state while offline")
+	}
+
+	for _, app := range a.monitorApps() {
+		var data []byte
+		if data, err = app.DownloadState(); err != nil {
+			return
+		}
+		if len(data) == 0 {
+			fmt.Println("No state has been pushed for", app.folderName)
+			continue
+		}
+
+		if app.parsedState, err = app.parseRawState(data); err != nil {
+			return
+		}
+		if err = app.saveJSON(app.stateFile(), app.getState()); err != nil {
+			return
+		}
+	}
+	return
 }
 
 func (a *App) LoadState() (err error) {
@@ -229,9 +364,12 @@ func (a *App) LoadState() (err error) {
 func (a *App) SyncState() (err error) {
 	// Sync with imgur
 	var data []byte
-	if a.Sync {
+	if a.Sync && !a.Offline() {
 		data, err = a.DownloadState()
 		if err != nil {
+			if a.checkOffline(err) {
+				return nil
+			}
 			return
 		}
 
@@ -243,9 +381,22 @@ func (a *App) SyncState() (err error) {
 			}
 
 			if downloadedState.stateTimestamp.After(a.stateTimestamp) {
+				local := a.parsedState
 				a.parsedState = downloadedState
+
+				// Another machine changed the background while we were offline.
+				// Take its state, but keep any bans made here
+				if !local.pendingSync || !a.mergeBans(local) {
+					return nil
+				}
+				a.pendingSync = true
 			}
 		}
+
+		// Push changes made while offline
+		if a.pendingSync {
+			return a.pushState()
+		}
 	}
 
 	// Conditional err above, make sure it is nil now
@@ -0,0 +1,111 @@
+package bgur
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Values for ScheduleRule.Sun
+const (
+	SunDay   = "day"
+	SunNight = "night"
+)
+
+// Julian date of the unix epoch and of the J2000 epoch
+const julianUnixEpoch = 2440587.5
+const julianJ2000 = 2451545.0
+
+// Altitude of the centre of the sun at sunrise and sunset, in degrees.
+// Accounts for refraction and the size of the sun
+const sunriseAltitude = -0.833
+
+// Location is used to work out sunrise and sunset offline
+type Location struct {
+	// Degrees north of the equator. Negative for the southern hemisphere
+	Latitude float64 `json:"latitude"`
+	// Degrees east of Greenwich. Negative for the western hemisphere
+	Longitude float64 `json:"longitude"`
+}
+
+func (l Location) Validate() error {
+	if l.Latitude < -90 || l.Latitude > 90 {
+		return fmt.Errorf("invalid latitude %g", l.Latitude)
+	}
+	if l.Longitude < -180 || l.Longitude > 180 {
+		return fmt.Errorf("invalid longitude %g", l.Longitude)
+	}
+	return nil
+}
+
+func julianToTime(julian float64) time.Time {
+	return time.Unix(0, int64((julian-julianUnixEpoch)*86400*float64(time.Second)))
+}
+
+func sin(degrees float64) float64 {
+	return math.Sin(degrees * math.Pi / 180)
+}
+
+func cos(degrees float64) float64 {
+	return math.Cos(degrees * math.Pi / 180)
+}
+
+// daylight returns when the sun is up on the UTC day of date, using the sunrise equation.
+// It is accurate to a few minutes. If the sun doesn't rise, sunrise and sunset are equal.
+// If it doesn't set, they cover the whole day.
+func (l Location) daylight(date time.Time) (sunrise, sunset time.Time) {
+	midnight := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	day := math.Ceil(float64(midnight.Unix())/86400 + julianUnixEpoch - julianJ2000 + 0.0008)
+
+	// Mean solar noon, then the position of the earth in its orbit
+	noon := day - l.Longitude/360
+	anomaly := math.Mod(357.5291+0.98560028*noon, 360)
+	centre := 1.9148*sin(anomaly) + 0.02*sin(2*anomaly) + 0.0003*sin(3*anomaly)
+	longitude := math.Mod(anomaly+centre+180+102.9372, 360)
+	transit := julianJ2000 + noon + 0.0053*sin(anomaly) - 0.0069*sin(2*longitude)
+
+	declination := math.Asin(sin(longitude) * sin(23.4397))
+	cosHourAngle := (sin(sunriseAltitude) - sin(l.Latitude)*math.Sin(declination)) /
+		(cos(l.Latitude) * math.Cos(declination))
+
+	switch {
+	case cosHourAngle > 1:
+		// Polar night
+		return julianToTime(transit), julianToTime(transit)
+	case cosHourAngle < -1:
+		// Midnight sun
+		return julianToTime(transit - 0.5), julianToTime(transit + 0.5)
+	}
+
+	hourAngle := math.Acos(cosHourAngle) * 180 / math.Pi
+	return julianToTime(transit - hourAngle/360), julianToTime(transit + hourAngle/360)
+}
+
+// IsDaytime checks if the sun is up at the location
+func (l Location) IsDaytime(now time.Time) bool {
+	// The daylight of a UTC day can start the day before or end the day after
+	for offset := -1; offset <= 1; offset++ {
+		sunrise, sunset := l.daylight(now.UTC().AddDate(0, 0, offset))
+		if !now.Before(sunrise) && now.Before(sunset) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextSunEvent returns the next sunrise or sunset after now. It is zero during
+// polar day or night.
+func (l Location) NextSunEvent(now time.Time) (next time.Time) {
+	for offset := -1; offset <= 2; offset++ {
+		sunrise, sunset := l.daylight(now.UTC().AddDate(0, 0, offset))
+		if sunrise.Equal(sunset) || sunset.Sub(sunrise) >= 24*time.Hour {
+			continue
+		}
+		for _, event := range []time.Time{sunrise, sunset} {
+			if event.After(now) && (next.IsZero() || event.Before(next)) {
+				next = event
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,72 @@
+package bgur
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/m1cr0man/bgur/pkg/oauth2"
+)
+
+// Where the Imgur token is kept. TokenStoreEncrypted is the default
+const (
+	// Plain JSON in token.json, as older versions of bgur did
+	TokenStoreFile = "file"
+	// Encrypted in token.enc with PassphraseEnv, or a secret for this machine
+	TokenStoreEncrypted = "encrypted"
+	// In the Secret Service keyring through secret-tool
+	TokenStoreKeyring = "keyring"
+)
+
+// PassphraseEnv is the environment variable which holds the passphrase for TokenStoreEncrypted
+const PassphraseEnv = "BGUR_PASSPHRASE"
+
+// Service name of the token in the keyring
+const keyringService = "bgur"
+
+// ValidateTokenStore checks that name is one of the TokenStore constants, or empty for the default
+func ValidateTokenStore(name string) error {
+	switch name {
+	case "", TokenStoreFile, TokenStoreEncrypted, TokenStoreKeyring:
+		return nil
+	}
+	return fmt.Errorf("unknown token store %s. Use %s, %s or %s",
+		name, TokenStoreFile, TokenStoreEncrypted, TokenStoreKeyring)
+}
+
+func (a *App) tokenFile() string {
+	return a.accountFile(a.ConfigDir, "token.json")
+}
+
+// openTokenStore opens the store named by TokenStore, moving a plain token.json into it
+func (a *App) openTokenStore() (oauth2.TokenStore, error) {
+	plain := oauth2.NewFileStore(a.tokenFile())
+
+	var store oauth2.TokenStore
+	switch a.TokenStore {
+	case TokenStoreFile:
+		return plain, nil
+
+	case TokenStoreKeyring:
+		store = oauth2.NewKeyringStore(oauth2.SecretTool{}, keyringService, a.accountName("token"))
+
+	case "", TokenStoreEncrypted:
+		passphrase := os.Getenv(PassphraseEnv)
+		if passphrase == "" {
+			var err error
+			if passphrase, err = oauth2.MachineSecret(); err != nil {
+				return nil, fmt.Errorf("%s. Set %s", err, PassphraseEnv)
+			}
+		}
+		store = oauth2.NewEncryptedFileStore(a.accountFile(a.ConfigDir, "token.enc"), passphrase)
+
+	default:
+		return nil, ValidateTokenStore(a.TokenStore)
+	}
+
+	if err := oauth2.Migrate(plain, store); err != nil {
+		// Keep using the plain token, it will be moved next time
+		fmt.Println("Failed to move token.json to the token store: ", err)
+		return plain, nil
+	}
+	return store, nil
+}
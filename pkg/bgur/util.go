@@ -1,8 +1,12 @@
 package bgur
 
 import (
+	"fmt"
 	"github.com/m1cr0man/bgur/pkg/imgur"
+	"io/ioutil"
 	"math/rand"
+	"os"
+	"path/filepath"
 )
 
 // Returns elements in A not in B
@@ -29,3 +33,39 @@ func DiffImages(sliceA, sliceB []imgur.Image) ([]imgur.Image, []imgur.Image) {
 func Randomise(images []imgur.Image) {
 	rand.Shuffle(len(images), func(i, j int) { images[i], images[j] = images[j], images[i] })
 }
+
+// writeFileAtomic writes to a temporary file first so that a partially
+// written file is never mistaken for a complete one
+func writeFileAtomic(filePath string, data []byte) error {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(filePath), "."+filepath.Base(filePath)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmpFile.Write(data)
+	if err2 := tmpFile.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Chmod(tmpFile.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), filePath)
+	}
+	if err != nil {
+		_ = os.Remove(tmpFile.Name())
+	}
+	return err
+}
+
+// FormatBytes formats a size in bytes to a human readable string
+func FormatBytes(bytes int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	size := float64(bytes)
+	unit := 0
+	for size >= 1024 && unit < len(units)-1 {
+		size /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f %s", size, units[unit])
+}
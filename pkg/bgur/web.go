@@ -0,0 +1,141 @@
+package bgur
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// Number of history entries shown in the web UI
+const webHistoryLength = 50
+
+// isLoopback checks if host, which may include a port, is this machine
+func isLoopback(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// isLocal checks that the request comes from this machine. Requests from other
+// sites in the browser, and sites which resolve to 127.0.0.1, are not local.
+func isLocal(req *http.Request) bool {
+	if !isLoopback(req.RemoteAddr) || !isLoopback(req.Host) {
+		return false
+	}
+	origin := req.Header.Get("Origin")
+	return origin == "" || origin == "http://"+req.Host
+}
+
+// localOnly rejects requests which are not local
+func localOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		if !isLocal(req) {
+			writeError(resp, http.StatusForbidden, "bgur can only be controlled from this computer")
+			return
+		}
+		handler(resp, req)
+	}
+}
+
+func writeJSON(resp http.ResponseWriter, status int, data interface{}) {
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(status)
+	_ = json.NewEncoder(resp).Encode(data)
+}
+
+type webError struct {
+	Error string `json:"error"`
+}
+
+func writeError(resp http.ResponseWriter, status int, message string) {
+	writeJSON(resp, status, webError{message})
+}
+
+// serveWeb adds the web UI to the server. Requests are passed to the
+// daemon loop through calls, the same as the control socket.
+func (a *App) serveWeb(calls chan<- controlCall) {
+	a.mux.HandleFunc("/", localOnly(a.handleWebUI))
+	a.mux.HandleFunc("/ui/control", localOnly(func(resp http.ResponseWriter, req *http.Request) {
+		handleWebControl(resp, req, calls)
+	}))
+	a.mux.HandleFunc("/ui/history", localOnly(a.handleWebHistory))
+	a.mux.HandleFunc("/ui/cache/", localOnly(a.handleWebCache))
+}
+
+func (a *App) handleWebUI(resp http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/" {
+		http.NotFound(resp, req)
+		return
+	}
+
+	// It will never fail, it's generated
+	page, _ := base64.StdEncoding.DecodeString(WebUI)
+	resp.Header().Set("Content-Type", "text/html;charset=UTF-8")
+	resp.Header().Set("Content-Encoding", "gzip")
+	_, _ = resp.Write(page)
+}
+
+func handleWebControl(resp http.ResponseWriter, req *http.Request, calls chan<- controlCall) {
+	// Forms can be posted from other sites without asking, JSON can't
+	if req.Method != http.MethodPost || req.Header.Get("Content-Type") != "application/json" {
+		writeError(resp, http.StatusBadRequest, "expected a POST request with a JSON body")
+		return
+	}
+
+	var request ControlRequest
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		writeError(resp, http.StatusBadRequest, fmt.Sprintf("failed to parse request: %s", err))
+		return
+	}
+
+	response := callDaemon(calls, request)
+	status := http.StatusOK
+	if !response.Success {
+		status = http.StatusInternalServerError
+	}
+	writeJSON(resp, status, response)
+}
+
+// recentHistory returns up to limit history entries matching search, newest first
+func (a *App) recentHistory(search string, limit int) ([]HistoryEntry, error) {
+	entries, err := ReadHistory(a.ConfigDir, a.account())
+	if err != nil {
+		return nil, err
+	}
+
+	recent := make([]HistoryEntry, 0, limit)
+	for i := len(entries) - 1; i >= 0 && len(recent) < limit; i-- {
+		if search == "" || entries[i].Matches(search) {
+			recent = append(recent, entries[i])
+		}
+	}
+	return recent, nil
+}
+
+func (a *App) handleWebHistory(resp http.ResponseWriter, req *http.Request) {
+	entries, err := a.recentHistory("", webHistoryLength)
+	if err != nil {
+		writeError(resp, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(resp, http.StatusOK, entries)
+}
+
+// handleWebCache serves downloaded images, so that thumbnails work offline
+func (a *App) handleWebCache(resp http.ResponseWriter, req *http.Request) {
+	name := strings.TrimPrefix(req.URL.Path, "/ui/cache/")
+	if name != filepath.Base(name) || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".json") {
+		http.NotFound(resp, req)
+		return
+	}
+	http.ServeFile(resp, req, filepath.Join(a.CacheDir, name))
+}
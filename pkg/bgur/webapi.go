@@ -0,0 +1,226 @@
+package bgur
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ApiTokenFileName holds the token which allows the API to be used from other machines
+const ApiTokenFileName = "api_token"
+
+// Filters for GET /api/images. Anything else is searched for in the id, title and link
+const (
+	FilterUsable     = "usable"
+	FilterUnusable   = "unusable"
+	FilterBanned     = "banned"
+	FilterDownloaded = "downloaded"
+)
+
+// apiCommands are the endpoints which change the background, by the name used in the URL.
+// They take POST requests, and the ones which need an argument take it as the last part of the path.
+var apiCommands = map[string]string{
+	"next":     CommandNext,
+	"previous": CommandPrevious,
+	"pin":      CommandPin,
+	"show":     CommandShow,
+	"ban":      CommandBan,
+	"unban":    CommandUnban,
+	"refresh":  CommandRefresh,
+}
+
+func ApiTokenFile(configDir string) string {
+	return filepath.Join(configDir, ApiTokenFileName)
+}
+
+// ApiToken reads the API token, creating it if it doesn't exist yet or is empty
+func ApiToken(configDir string) (string, error) {
+	tokenFile := ApiTokenFile(configDir)
+	if data, err := ioutil.ReadFile(tokenFile); err == nil {
+		if token := strings.TrimSpace(string(data)); token != "" {
+			return token, nil
+		}
+		// An empty token would let anybody in. Replace the file so that it is only readable by us
+		if err = os.Remove(tokenFile); err != nil {
+			return "", err
+		}
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	randBytes := make([]byte, 24)
+	if _, err := rand.Read(randBytes); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(randBytes)
+
+	// Anybody with the token can control bgur
+	return token, ioutil.WriteFile(tokenFile, []byte(token+"\n"), 0600)
+}
+
+// apiAccess allows requests from this machine, and requests from elsewhere
+// which have the token in an "Authorization: Bearer" header
+func apiAccess(token string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		given := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if !isLocal(req) && (token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1) {
+			writeError(resp, http.StatusUnauthorized,
+				fmt.Sprintf("requests from other machines need the token in %s", ApiTokenFileName))
+			return
+		}
+		handler(resp, req)
+	}
+}
+
+// serveApi adds the JSON API to the server. Every endpoint accepts a monitor
+// query parameter, which defaults to all monitors.
+//
+//	GET  /api/status
+//	GET  /api/images?filter=usable|unusable|banned|downloaded|TEXT
+//	GET  /api/bans
+//	GET  /api/history?search=TEXT&limit=N
+//	POST /api/next, /api/previous, /api/refresh
+//	POST /api/pin/DURATION, /api/show/ID, /api/ban[/ID], /api/unban/ID
+func (a *App) serveApi(calls chan<- controlCall) error {
+	token, err := ApiToken(a.ConfigDir)
+	if err != nil {
+		return fmt.Errorf("failed to create the API token: %s", err)
+	}
+
+	a.mux.HandleFunc("/api/", apiAccess(token, func(resp http.ResponseWriter, req *http.Request) {
+		a.handleApi(resp, req, calls)
+	}))
+	return nil
+}
+
+func (a *App) handleApi(resp http.ResponseWriter, req *http.Request, calls chan<- controlCall) {
+	path := strings.Split(strings.Trim(strings.TrimPrefix(req.URL.Path, "/api/"), "/"), "/")
+	request := ControlRequest{Monitor: req.URL.Query().Get("monitor")}
+
+	if command, ok := apiCommands[path[0]]; ok {
+		if req.Method != http.MethodPost {
+			writeError(resp, http.StatusMethodNotAllowed, "expected a POST request")
+			return
+		}
+
+		request.Command = command
+		switch {
+		case len(path) > 2:
+			writeError(resp, http.StatusNotFound, "unknown endpoint")
+			return
+		case len(path) == 2 && command == CommandPin:
+			request.Duration = path[1]
+		case len(path) == 2 && (command == CommandShow || command == CommandBan || command == CommandUnban):
+			request.ImageId = path[1]
+		case len(path) == 2:
+			writeError(resp, http.StatusBadRequest, fmt.Sprintf("/api/%s doesn't take an argument", path[0]))
+			return
+		case command == CommandPin || command == CommandShow || command == CommandUnban:
+			writeError(resp, http.StatusBadRequest, fmt.Sprintf("expected /api/%s/ARGUMENT", path[0]))
+			return
+		}
+
+		response := callDaemon(calls, request)
+		status := http.StatusOK
+		if !response.Success {
+			status = http.StatusInternalServerError
+		}
+		writeJSON(resp, status, response)
+		return
+	}
+
+	if req.Method != http.MethodGet {
+		writeError(resp, http.StatusMethodNotAllowed, "expected a GET request")
+		return
+	}
+	if len(path) > 1 {
+		writeError(resp, http.StatusNotFound, "unknown endpoint")
+		return
+	}
+
+	switch path[0] {
+	case "status":
+		request.Command = CommandStatus
+	case "images":
+		request.Command = CommandImages
+	case "bans":
+		request.Command = CommandBans
+	case "history":
+		a.handleApiHistory(resp, req)
+		return
+	default:
+		writeError(resp, http.StatusNotFound, "unknown endpoint")
+		return
+	}
+
+	response := callDaemon(calls, request)
+	if !response.Success {
+		writeError(resp, http.StatusInternalServerError, response.Error)
+		return
+	}
+
+	switch request.Command {
+	case CommandStatus:
+		writeJSON(resp, http.StatusOK, response.Status)
+	case CommandImages:
+		writeJSON(resp, http.StatusOK, filterImages(response.Images, req.URL.Query().Get("filter")))
+	case CommandBans:
+		writeJSON(resp, http.StatusOK, response.Banned)
+	}
+}
+
+func (a *App) handleApiHistory(resp http.ResponseWriter, req *http.Request) {
+	limit := webHistoryLength
+	if value := req.URL.Query().Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 0 {
+			writeError(resp, http.StatusBadRequest, "limit must be a positive number")
+			return
+		}
+	}
+
+	entries, err := a.recentHistory(req.URL.Query().Get("search"), limit)
+	if err != nil {
+		writeError(resp, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(resp, http.StatusOK, entries)
+}
+
+// filterImages keeps the images which match filter. See FilterUsable and co.
+func filterImages(images []ImageInfo, filter string) []ImageInfo {
+	if filter == "" {
+		return images
+	}
+
+	filtered := make([]ImageInfo, 0, len(images))
+	query := strings.ToLower(filter)
+	for _, image := range images {
+		var keep bool
+		switch filter {
+		case FilterUsable:
+			keep = image.Usable && !image.Banned
+		case FilterUnusable:
+			keep = !image.Usable
+		case FilterBanned:
+			keep = image.Banned
+		case FilterDownloaded:
+			keep = image.Downloaded
+		default:
+			keep = strings.Contains(strings.ToLower(image.Id), query) ||
+				strings.Contains(strings.ToLower(image.Title), query) ||
+				strings.Contains(strings.ToLower(image.Link), query)
+		}
+		if keep {
+			filtered = append(filtered, image)
+		}
+	}
+	return filtered
+}
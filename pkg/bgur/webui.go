@@ -0,0 +1,3 @@
+package bgur
+
+const WebUI = "H4sIAAAAAAAAA71abW/bOBL+nl/B9eJWMmrLSYvdLVw7i2vaojls02Cb4nAIjIKRaJuNROlEKmk26/9+M3yRqbckvQ+bAo0szgznjc/M0Fn88ObjycV/zt+SrcrS44MF/iIpFZvliIkRvmA0OT4g8LPImKIk3tJSMrUcfb54N3058pcEzdhydMPZbZGXakTiXCgmgPSWJ2q7TNgNj9lUf5gQLrjiNJ3KmKZseeQEKa5Sdvx6U5WLmXk276W6c8/4c5Und+S+/og/a9htuqYZT+/mRFIhp5KVfP2qQZTRcsPFnBwSWqm8vfbNKDcnR88PD4tvzeWCJgkXG+Q9Yllz7YrG15syr0QyJz8eXeG/JkGcp3kJa0mS7Bd2B/UjbRnj6F/G6176q0qpXEyIZCmLFXqzqFSfQyT/k827Ctd+iJ77S94OP2ZMSrphLaEZF9Mt45utAqnRzwPMESvLvBywaf3rr/1Mm5InLZ6EyyKlENB1ylrxwDfT25IWc4L/Nxc3+PpwWD+91ZpDmnUMdJ5pCnSJ8UsnL+KqlGhXkXNI9/KB/Xi2aW02LLX2cTcR86uvEPPpmsNynN/4W5qjUSYM9HlRfCMyT2FfVcJpKGgJZ/Fhb8S0UDwXw3l0GL1sZxIqsE7z2znZ8iRhouW2LVdsCpvHwC3ybqAU+6amexksTXkhuezXE1yNRvQ40hg9fezYRFdUCJZMSFSJStKrtB39HDTl6g4tfdEvIcsBuCC3uzr4+HF4+I8uuLiYvmjG1JOuelTam5bSQjKMuXnql9A+QXvYwqNuIuiefQkaZWcWZhczA/sLxFksAkcWkuHh4GCR8BsHyxp/CE+WI+uYEdGwvRx9sJ+PQaqmsiwGukgu4pTH18sRVokyT8OgKKFA5JUMxqPjc/u8mBnyx3gFpBHyncHvFo+BRtQwqUqK+T0iNzStQMWj7YhgYi9HP9dqv89vSZqLDVE5Kbggass8eIfDUI4eNYSLYELu3XZzkuRxlUHiRhum3qYMH1/fnSZh4EiCcaRV2qHpXDxiNSRxCISvqXiqe0q2Lpncoof+MI9gSApptRewmOmoLgoTTAP+GLwCI759fnwGjpHb/BaSCfLg+bFOAz/yEqm1EE3/uSBCR6NBWxVxDjVkA91BSqVcjhB7mozvrGaarzg+QVsIFXDisBxBWFALwhXiSaT1q6VrEvmA7PdcgqZ3Vrg5bsi4Ne+RWL9EchmXvFBE3RWQFohTs6/0hpq3NgduaEmc9WRJLlevzElcVyLWSMpMtENFNxNClSo5+JvJCbRRPE0AzMbecUVpLAU5db7EJaOKvd0LGe+P7EddBqJrdifDvWTy11/kfjeOIE/f0ngb1pqEQDhuYQNLL+HtCnbcC9BvPGDwdgyd0rjJ5apvE03R3SaiRcFEcoKrITo0XxsPkOVySQIJm4tNQH5rW34BXj/LE+bkzg3XuF+/kqmqRJ+bV7tWLDBtPpi8DjMJ8eDyLbYp7RC4xmc5fG4tSeBtbl9FHApMiXoDP+zSJdC5eQatMhBYDcDwQHdMAVgYBAPqaxBlicXV0FfbWj6sr+FxMNPYYDYjnyXTOCczmqbkNAOoh89VdiUoTyd41LgkWRVvSYoVjOEqNdC4roBDH7umtjV7qBfbPk5y9I9eilIuriNwijoVCfv2cR0GUdCNqkcrqyuTMeHhBCWNyTMSZAH830uEFG2L36GhCOuIJ2hHnBd3xMJ9DDnNoHWBRDe+iKkIFLlioAsuJU1TNXlyMWywtWB/StoHhK+J4YqMrC9rDrj000+gDZeRLGPIKuuaWcVnmmgWjMmCHLZF6R7AcoGHfXrwDxMxnKbPf5ye5FmRCwSVzr7jZuOy2x+1/rw0XbSRMyF1dk9cGerxhEPFwPBisaz55nsRCDNBUAuau4eJqdVzG279AWnNR57sJuSyBUB2P2jacLOOx8BZ807KTjpk4DE8pfOBkHcZ0pya5itI6Z93QZeApmrQjGYYWtJ9F9q2HS1D0wfErca1gNW4P5Ql+2/FpAoLihM69n7tc5vrnbDW3e/2eYL526Y23XRN3bE8Y2qbw7gcnH/8dNHjGZQ3J//69PEsMueYr+/MJl1abFZZKefkPjgxdw7TCygyAUiHwgMpo5us2VcJTto13dpugf3zyhTUNuMLa8k4AnwQXr2DVqpoW23ZcSnCLcMOV0IV7Tu46McfNF9+jaFDOjNK91Gbo15CLyTYLdGFJNxzIL8WJRVVlcSK1DrZTaPbpQQEtYCgp+q2E8g1nNDjZVTAnAW7lrSdRPryZukaGDjsfCPCe8uD841ltlVr3i19OyvZdjt7xfwaH/w7L68hcaKor564XDcIafQObNK349X2fmOXN4AKQcu1dg88/mHDbQC0qtEwQawekg7LkW0cAPXKij0hCHo88GRiVoGBa15mYXDGYNY2HbQudXai9iYcuqFc/BaMx53rGztMgHzf3t1gs3WKyNMth7UgpEHQ0hRfeDLfI/ggQEEdLD/pjA5NYvuSvVbcLLppXb46aGSgHVuXT+iYXvUwsgR30I9+Q6WDZ97qLvD9xYffsQYHnWU9Zkf2cgsbRatmlDKxUVtyTI6wJ9TtoMD86kjwG+q6FuR1HdBazXX1rBtS+PhP6HrcXsFu7LevToNuT2+XOmn6PYpYGS4eDa1aaw21Gmfbc3jtf5a0QovZBQkMSf54dKUf3ppxMHjf7yPd6jIQm+rxsAO2wXkuuZaA3ZmTEhXu7TNypJtb3by5VZUrmuJb7Ff9BTPSd8tjcALd+gaSFomxVryB0areDJCefYkNBSBf/nuON/KfTO/cU2wDvGMhhqFfIo79VmJXYEPe6lWnD659gFFIvlRC8bSv+lm3RkUlt2FwrqmJpu5XqiGvo9Vg09vMjN5kT/hNq4Ot0aPTiTZat+0L17K1joBufPGsShiDmZDBqicOtRiKUrYlW++P0n4OgqpBS0h/UOrLVUrFdb9OfV2ybor7JOqmtblQd5vN14jknb0eNKZwLnHx/ZpzARMhCcarVu60caIBA+6e6SEUcDQ+Crh3gyDgXfn4wA2TE8zFPiIMtIVZHWS8+uh0Nr5Jl4crz6USPnp3MU4KTInO57XNg9cznVrcMNnP7uZUh3VkuB1yTYtf79u94xP6FlPctRBppOA9mWlQ2i2k/sbigdAadj+wyDEYVEP+VIfpMuPf4uhcN18tYNk2T1i87XRtv2pwFd1999DuGvfXANxcjPUYX2NSvf8zMMVRBg/BmPbAcIy9yf1vCrW9jA1B8ZIz2Y6xuZ19IMj20tZ3o+YZDLPdqCfOuNIZX42wXsxXpcOpYShTiaOpi5HeBsAy6ymMD8LiXpYRkYH6UJHMDcn3cXol5smcPaXGSBsuMc5UWxfMR9fprx4F876sMeNU9zrpvAT8khCpNA0vW5jbO+TtZ00HFA8Omy0nNYTWOehdrvRcElSpkt2C4A0zlgYw3g7srWOlhw84eAJSukMbfUcZ+n9KUaMcDe/eKFB7Cy2ie3zG6aZMTaxZUMf0Q905jPtkOchwwo5Wf9d4/YS79VzYrnipU9Ww+3cAkqlT/BsBGGFCfD8hvxzCD6wuZuZ7Jfwezn7nOjN/kfM/WHxcFqIjAAA="
@@ -12,20 +12,54 @@ import (
 	"golang.org/x/oauth2"
 )
 
+// DefaultClientID is the app registered for bgur. Every user of it shares its rate limit
+const DefaultClientID = "825af7b91a9dfbf"
+
 type API struct {
 	*oa2.API
+	// App registered at https://api.imgur.com/oauth2/addclient. Defaults to DefaultClientID
+	ClientID string
+	// Set for apps registered as confidential clients
+	ClientSecret   string
 	unauthedClient *http.Client
 }
 
+// StatusError is returned when Imgur responds with an error status code
+type StatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Body       []byte
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("failed to %s %s. Status code %d. Response: %s",
+		e.Method, e.URL, e.StatusCode, e.Body)
+}
+
+// IsUnavailable checks if an error was caused by the network or Imgur being down,
+// as opposed to a bad request
+func IsUnavailable(err error) bool {
+	switch e := err.(type) {
+	case *url.Error:
+		// A rejected token is not an outage
+		_, rejected := e.Err.(*oa2.RejectedError)
+		return !rejected
+	case *StatusError:
+		return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+	}
+	return false
+}
+
 func responseProcessor(res *http.Response, olderr error) (body []byte, err error) {
 	if olderr != nil {
-		return
+		return nil, olderr
 	}
+	defer res.Body.Close()
 
 	body, err = ioutil.ReadAll(res.Body)
 	if res.StatusCode > 299 {
-		err = fmt.Errorf("failed to %s %s. Status code %d. Response: %s",
-			res.Request.Method, res.Request.URL, res.StatusCode, body)
+		err = &StatusError{res.Request.Method, res.Request.URL.String(), res.StatusCode, body}
 	}
 	return
 }
@@ -63,16 +97,21 @@ func (i *API) delete(url string) (body []byte, err error) {
 	return responseProcessor(i.API.Client.Do(req))
 }
 
-func (i *API) Authorise(tokenFile string) error {
+func (i *API) Authorise() error {
+	clientID := i.ClientID
+	if clientID == "" {
+		clientID = DefaultClientID
+	}
 	i.SetConfig(&oauth2.Config{
-		ClientID: "825af7b91a9dfbf",
+		ClientID:     clientID,
+		ClientSecret: i.ClientSecret,
 		Endpoint: oauth2.Endpoint{
 			AuthURL:   "https://api.imgur.com/oauth2/authorize",
 			TokenURL:  "https://api.imgur.com/oauth2/token",
 			AuthStyle: oauth2.AuthStyleInParams,
 		},
 	})
-	return i.API.Authorise(tokenFile)
+	return i.API.Authorise()
 }
 
 func (i *API) GetAlbums() (albums []Album, err error) {
@@ -297,9 +336,9 @@ func (i *API) GetFavourites(folderOwner string) (data []ImageOrAlbum, err error)
 	}
 }
 
-func NewAPI(authUrl string) *API {
+func NewAPI(callbackURL string) *API {
 	return &API{
-		API:            oa2.NewAPI(authUrl),
+		API:            oa2.NewAPI(callbackURL),
 		unauthedClient: &http.Client{},
 	}
 }
@@ -1,25 +1,41 @@
 package oauth2
 
 import (
+	"bufio"
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
-	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"github.com/pkg/browser"
 	"golang.org/x/oauth2"
-	"io/ioutil"
+	"io"
 	"net/http"
-	"strconv"
+	"net/url"
+	"os"
+	"strings"
 	"time"
 )
 
 type API struct {
 	authConfig *oauth2.Config
-	AuthUrl    string
-	Username   string
-	Client     *http.Client
+	// Where the provider sends the browser after authorising, such as http://localhost:8099/oauthcallback
+	CallbackURL string
+	// How long to wait for the browser. Defaults to DefaultAuthTimeout
+	AuthTimeout time.Duration
+	// Ask for the redirect address on stdin instead of waiting for the browser
+	Headless bool
+	Username string
+	Client   *http.Client
+	// Where the token is kept between runs
+	Store TokenStore
+}
+
+func (i *API) authTimeout() time.Duration {
+	if i.AuthTimeout > 0 {
+		return i.AuthTimeout
+	}
+	return DefaultAuthTimeout
 }
 
 type TokenWithUsername struct {
@@ -27,106 +43,167 @@ type TokenWithUsername struct {
 	Username string        `json:"username,omitempty"`
 }
 
-func (i *API) AuthFromWeb() (*oauth2.Token, error) {
+// randomString returns length random bytes, encoded so that they are safe in a URL
+func randomString(length int) (string, error) {
+	randBytes := make([]byte, length)
+	if _, err := rand.Read(randBytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(randBytes), nil
+}
+
+// codeChallenge derives the PKCE S256 challenge from the verifier
+func codeChallenge(verifier string) string {
+	hash := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// authRequest is one attempt at authorising with the provider
+type authRequest struct {
+	state    string
+	verifier string
+	url      string
+}
 
+func (i *API) newAuthRequest() (req authRequest, err error) {
 	// Generate a random string for the state value
 	// Prevents XSS
-	randBytes := make([]byte, 24)
-	if _, err := rand.Read(randBytes); err != nil {
+	if req.state, err = randomString(24); err != nil {
+		return
+	}
+
+	// Proves that the code is exchanged by whoever asked for it
+	if req.verifier, err = randomString(32); err != nil {
+		return
+	}
+
+	req.url = i.authConfig.AuthCodeURL(req.state, oauth2.AccessTypeOffline,
+		oauth2.SetAuthURLParam("code_challenge", codeChallenge(req.verifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"))
+	return
+}
+
+// exchange swaps the code for a token. Confidential clients also send the
+// ClientSecret of the config, here and when refreshing.
+func (i *API) exchange(ctx context.Context, req authRequest, code string) (*oauth2.Token, error) {
+	return i.authConfig.Exchange(ctx, code,
+		oauth2.SetAuthURLParam("code_verifier", req.verifier))
+}
+
+// AuthFromWeb runs the authorization code flow with PKCE. The provider redirects
+// the browser to CallbackURL with a code, which is exchanged for a token at TokenURL.
+// It gives up when ctx is done.
+func (i *API) AuthFromWeb(ctx context.Context) (*oauth2.Token, error) {
+	authReq, err := i.newAuthRequest()
+	if err != nil {
+		return nil, err
+	}
+
+	callback, err := i.listenCallback(authReq)
+	if err != nil {
 		return nil, err
 	}
-	stateStr := hex.EncodeToString(randBytes)
 
 	// Open the provider auth page
-	url := i.authConfig.AuthCodeURL(stateStr, oauth2.AccessTypeOffline,
-		oauth2.SetAuthURLParam("response_type", "token"))
-	if err := browser.OpenURL(url); err != nil {
-		fmt.Println("Open this URL to authorise the app: ", url)
+	if err := browser.OpenURL(authReq.url); err != nil {
+		fmt.Println("Open this URL to authorise the app: ", authReq.url)
 	} else {
 		fmt.Println("Authorisation page opened. Check your browser.")
 	}
 
-	// Decode CallbackPage
-	// It will never fail, it's hard coded
-	CallbackPageParsed, _ := base64.StdEncoding.DecodeString(CallbackPage)
-	tokenChannel := make(chan oauth2.Token)
-
-	http.HandleFunc(i.AuthUrl, func(resp http.ResponseWriter, req *http.Request) {
-		if req.Method == http.MethodGet {
-			resp.Header().Set("Content-Type", "text/html;charset=UTF-8")
-			resp.Header().Set("Content-Encoding", "gzip")
-
-			// Check state
-			if req.URL.Query().Get("state") != stateStr {
-				fmt.Println("STATE STRING DOES NOT MATCH! You have probably been hacked. " +
-					"Check your system security and try again")
-				resp.WriteHeader(401)
-				close(tokenChannel)
-				return
-			}
-
-			if _, err := resp.Write(CallbackPageParsed); err != nil {
-				fmt.Println("Failed to write GET response: ", err)
-				close(tokenChannel)
-			}
-
-		} else if req.Method == http.MethodPost {
-
-			if err := req.ParseForm(); err != nil {
-				fmt.Println("Failed to parse POST form data: ", err)
-				close(tokenChannel)
-				return
-			}
-
-			// Parse form data to an Oauth2 token
-			expiry, _ := strconv.Atoi(req.FormValue("expires_in"))
-			tokenChannel <- oauth2.Token{
-				Expiry:       time.Now().Add(time.Second * time.Duration(expiry)),
-				TokenType:    req.FormValue("token_type"),
-				AccessToken:  req.FormValue("access_token"),
-				RefreshToken: req.FormValue("refresh_token"),
-			}
-
-			close(tokenChannel)
-			resp.WriteHeader(201)
-		}
-	})
+	code, err := callback.wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return i.exchange(ctx, authReq, code)
+}
 
-	token, ok := <-tokenChannel
-	if !ok {
-		return nil, fmt.Errorf("failed to get token")
+// AuthFromTerminal authorises without a browser on this machine. The URL is opened
+// on any other machine, and the address the browser is redirected to is pasted
+// into input. A refresh token from another bgur install can be pasted instead.
+func (i *API) AuthFromTerminal(input io.Reader) (*oauth2.Token, error) {
+	authReq, err := i.newAuthRequest()
+	if err != nil {
+		return nil, err
 	}
 
-	return &token, nil
-}
+	fmt.Println("Open this URL in a browser on any machine to authorise the app:")
+	fmt.Println(authReq.url)
+	fmt.Println("The browser will be sent to a page which does not load. " +
+		"Paste the address of that page here, or paste a refresh token:")
+
+	line, err := bufio.NewReader(input).ReadString('\n')
+	line = strings.TrimSpace(line)
+	if line == "" {
+		if err == nil {
+			err = fmt.Errorf("nothing was pasted")
+		}
+		return nil, fmt.Errorf("failed to read the redirect address: %s", err)
+	}
 
-func (i *API) AuthFromFile(tokenFile string) (token TokenWithUsername, err error) {
+	// A refresh token is one word. Authorise exchanges it for an access token
+	if !strings.ContainsAny(line, "?=") {
+		return &oauth2.Token{RefreshToken: line}, nil
+	}
 
-	// Try reading the token from the file
-	tokenData, err := ioutil.ReadFile(tokenFile)
+	redirect, err := url.Parse(line)
 	if err != nil {
-		return
+		return nil, fmt.Errorf("failed to parse the redirect address: %s", err)
 	}
-	err = json.Unmarshal(tokenData, &token)
-	return
+	query := redirect.Query()
+	if redirect.RawQuery == "" {
+		// Only the query string was pasted
+		if query, err = url.ParseQuery(line); err != nil {
+			return nil, fmt.Errorf("failed to parse the redirect address: %s", err)
+		}
+	}
+
+	if query.Get("state") != authReq.state {
+		return nil, fmt.Errorf("state does not match. Paste the address which the URL above redirected to")
+	}
+	if errStr := query.Get("error"); errStr != "" {
+		return nil, fmt.Errorf("authorisation was refused: %s", errStr)
+	}
+	if query.Get("code") == "" {
+		return nil, fmt.Errorf("the redirect address has no code in it")
+	}
+
+	return i.exchange(context.Background(), authReq, query.Get("code"))
 }
 
-func (i *API) SaveAuth(tokenFile string, token TokenWithUsername) error {
-	jsonData, _ := json.Marshal(token)
-	return ioutil.WriteFile(tokenFile, jsonData, 0640)
+// AuthFromStore reads the token saved by the last run
+func (i *API) AuthFromStore() (token TokenWithUsername, err error) {
+	if i.Store == nil {
+		return token, ErrNoToken
+	}
+	return i.Store.Load()
+}
+
+func (i *API) SaveAuth(token TokenWithUsername) error {
+	return i.Store.Save(token)
 }
 
 func (i *API) SetConfig(config *oauth2.Config) {
 	i.authConfig = config
 }
 
-func (i *API) Authorise(tokenFile string) error {
-	token, err := i.AuthFromFile(tokenFile)
+// Authorise loads the token from Store, or asks the user for a new one, and sets up Client
+func (i *API) Authorise() error {
+	token, err := i.AuthFromStore()
+	if err != nil && err != ErrNoToken {
+		fmt.Println("Failed to read the saved token, authorising again: ", err)
+	}
 
 	// Don't complain, just do web auth
 	if err != nil || token.Token == nil || token.Token.AccessToken == "" {
-		err = nil
-		newToken, err := i.AuthFromWeb()
+		var newToken *oauth2.Token
+		if i.Headless {
+			newToken, err = i.AuthFromTerminal(os.Stdin)
+		} else {
+			ctx, cancel := context.WithTimeout(context.Background(), i.authTimeout())
+			newToken, err = i.AuthFromWeb(ctx)
+			cancel()
+		}
 
 		if err != nil {
 			return fmt.Errorf("failed to get authorisation token from Imgur: %s", err)
@@ -137,38 +214,40 @@ func (i *API) Authorise(tokenFile string) error {
 		}
 	}
 
+	// Known before refreshing so that it can be used offline
+	i.Username = token.Username
+
 	// Force a token refresh so that we can get the username
 	if token.Username == "" {
 		token.Token.Expiry = time.Now().Add(-time.Hour)
 	}
-	source := i.authConfig.TokenSource(context.Background(), token.Token)
-	newToken, err := source.Token()
+	source := &persistingSource{api: i, source: i.authConfig.TokenSource(context.Background(), token.Token)}
+	_, saveErr, err := source.refresh()
 	if err != nil {
 		return err
 	}
-	token.Token = newToken
+	if saveErr != nil {
+		return fmt.Errorf("failed to save auth data: %s", saveErr)
+	}
 
 	// Set up authenticated http client
 	i.Client = oauth2.NewClient(context.Background(), source)
 
-	// If token was refreshed set the username
-	if newUsername, ok := newToken.Extra("account_username").(string); ok {
-		token.Username = newUsername
-	}
-	i.Username = token.Username
-
-	// Save the token
-	if err = i.SaveAuth(tokenFile, token); err != nil {
-		return fmt.Errorf("failed to save auth data: %s", err)
-	}
-
 	fmt.Println("Token received!")
 
 	return nil
 }
 
-func NewAPI(authUrl string) *API {
+// saveToken saves a new token, taking the username from it if it was refreshed
+func (i *API) saveToken(token *oauth2.Token) error {
+	if username, ok := token.Extra("account_username").(string); ok && username != i.Username {
+		i.Username = username
+	}
+	return i.SaveAuth(TokenWithUsername{token, i.Username})
+}
+
+func NewAPI(callbackURL string) *API {
 	return &API{
-		AuthUrl: authUrl,
+		CallbackURL: callbackURL,
 	}
 }
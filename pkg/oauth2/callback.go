@@ -0,0 +1,132 @@
+package oauth2
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"time"
+)
+
+// How long AuthFromWeb waits for the browser when AuthTimeout isn't set
+const DefaultAuthTimeout = 5 * time.Minute
+
+// callbackServer receives the redirect from the provider at CallbackURL.
+// Each one has its own ServeMux, so authorising again never clashes with the last attempt.
+type callbackServer struct {
+	server  *http.Server
+	results chan callbackResult
+}
+
+// callbackResult is the code from the callback, or why there isn't one
+type callbackResult struct {
+	code string
+	err  error
+}
+
+func isAddrInUse(err error) bool {
+	if opErr, ok := err.(*net.OpError); ok {
+		if sysErr, ok := opErr.Err.(*os.SyscallError); ok {
+			return sysErr.Err == syscall.EADDRINUSE
+		}
+	}
+	return false
+}
+
+// listenCallback starts listening before the browser is opened, so that a port
+// in use is reported straight away rather than after the user has logged in
+func (i *API) listenCallback(authReq authRequest) (*callbackServer, error) {
+	callback, err := url.Parse(i.CallbackURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid callback URL %s: %s", i.CallbackURL, err)
+	}
+	port := callback.Port()
+	if port == "" {
+		port = "80"
+	}
+
+	// Only listen where the provider sends the browser, usually localhost
+	listener, err := net.Listen("tcp", net.JoinHostPort(callback.Hostname(), port))
+	if isAddrInUse(err) {
+		return nil, fmt.Errorf("port %s is already in use, maybe by a running bgur daemon. "+
+			"Stop it while authorising, or authorise with -headless", port)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to listen for the authorisation callback on port %s: %s", port, err)
+	}
+
+	// Decode CallbackPage
+	// It will never fail, it's hard coded
+	CallbackPageParsed, _ := base64.StdEncoding.DecodeString(CallbackPage)
+	s := &callbackServer{results: make(chan callbackResult, 1)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(callback.Path, func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			resp.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := req.URL.Query()
+
+		// Check state
+		if query.Get("state") != authReq.state {
+			fmt.Println("STATE STRING DOES NOT MATCH! You have probably been hacked. " +
+				"Check your system security and try again")
+			resp.WriteHeader(401)
+			return
+		}
+
+		var result callbackResult
+		if errStr := query.Get("error"); errStr != "" {
+			if description := query.Get("error_description"); description != "" {
+				errStr += ": " + description
+			}
+			result.err = fmt.Errorf("authorisation was refused: %s", errStr)
+			http.Error(resp, "Authorisation was refused: "+errStr, http.StatusForbidden)
+		} else if result.code = query.Get("code"); result.code == "" {
+			result.err = fmt.Errorf("the callback has no code in it")
+			http.Error(resp, "The callback has no code in it", http.StatusBadRequest)
+		} else {
+			resp.Header().Set("Content-Type", "text/html;charset=UTF-8")
+			resp.Header().Set("Content-Encoding", "gzip")
+			if _, err := resp.Write(CallbackPageParsed); err != nil {
+				fmt.Println("Failed to write GET response: ", err)
+			}
+		}
+
+		// Only the first callback counts
+		select {
+		case s.results <- result:
+		default:
+		}
+	})
+
+	s.server = &http.Server{Handler: mux}
+	go func() {
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			fmt.Println("Authorisation callback server failed: ", err)
+		}
+	}()
+	return s, nil
+}
+
+// wait returns the code from the callback, or an error if ctx is done first.
+// The server is stopped either way.
+func (s *callbackServer) wait(ctx context.Context) (code string, err error) {
+	select {
+	case result := <-s.results:
+		code, err = result.code, result.err
+	case <-ctx.Done():
+		err = fmt.Errorf("gave up waiting for authorisation: %s", ctx.Err())
+	}
+
+	// Give the browser a moment to receive the page
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	_ = s.server.Shutdown(shutdownCtx)
+	return
+}
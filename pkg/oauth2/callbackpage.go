@@ -1,3 +1,3 @@
 package oauth2
 
-const CallbackPage = "H4sIAAAAAAAAA21UTW/bMAy951dwvthGG3vpaUjsAGuXAT21WLPDjorM2tocyZXoutmQ/z7KStMsHS8y+PH49Ei5+PDl7mb9434FDW3b5aTwB7RC12WEOvIOFNVyAmzFFkmAbIR1SGX0ff11+ik6hEhRi8vrurfwuafGWPVbkDK6yENkUuQBqNiYaudhZ8vXRKVr6KyprdhewkZYhEFRA73jmtlywrlXoCrmY62x0ZK9V97rpFUdAe06LCPCF8p/imcRvAdaj72WngW4xgwrX54wSAp/xqi3ysh+i5qyGmnVov+83t1WSTw2i9NMaY12zehQQjxCzCGGC+D4YkTZT8Yjz2H1QlZIZtQgPPVod+DI+ssJXfFtEBSBcGPY4tM16/BaeUugHIjWskQ7UDrkqLoheDR2K2hMfBY24D4E2BJaI0eVs0a4JnP9JjRMZuniyOr+7mH9nhIZ9nHPTtQIzsDGT04K7Qch0TnmGhREkk2icYBvyACOkjg3ggcnRdtuhPwVX56oyQvSmIoF8k3jy6Pfz3x+yv0t5LcCrZufoHiLb4wmHsZ0zeONGVF0XavCbfOX6TAMU6/MtLctamkqrBaviznuZXxE24cppenRk7EaOjnuRmLRdelZf/UY/JkjQb2Dsizh6uPsPM3boHRlhky2xmGSLv6J7wFbHvz7ohHbL22SntPZuvp/bby9rfEptwteSL+SvnDxrm5/TulNmRNJWFme8xGfa4o8PCX/dA9vNg//iL/pFMf5NAQAAA=="
+const CallbackPage = "H4sIAAAAAAAAAy2Puw7DIAxFd77Czd6ibB0IUp9rO6RDRhcQoCYQBWdov74g4uVKvsdHsthdH5d+eN7A0TRKJkrAiMF2jQlNWRjUkkEeMRlCUA6XZKhrXv19f2y2ijyNRp7tusBpJRcX/0PyMQheGyZ4FYl31N+ibSvtE2A9SEZnqJWMiVkOcQWFAdQYkwFyGZvRGsCgwUZ4o/oA5cyKg+Bz8W9iXh/5A8Lx6AvZAAAA"
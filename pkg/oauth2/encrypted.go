@@ -0,0 +1,156 @@
+package oauth2
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Number of PBKDF2 rounds used to turn the passphrase into a key
+const keyIterations = 100000
+
+const keyLength = 32
+
+// Files which hold a stable, per-install id on Linux
+var machineIdFiles = []string{"/etc/machine-id", "/var/lib/dbus/machine-id"}
+
+// EncryptedFileStore keeps the token in a file encrypted with AES-GCM.
+// The key is derived from a passphrase, see MachineSecret for a default.
+type EncryptedFileStore struct {
+	Path       string
+	passphrase string
+}
+
+// encryptedToken is the file format of EncryptedFileStore
+type encryptedToken struct {
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Data       []byte `json:"data"`
+}
+
+func NewEncryptedFileStore(path, passphrase string) *EncryptedFileStore {
+	return &EncryptedFileStore{Path: path, passphrase: passphrase}
+}
+
+// MachineSecret returns a secret which is stable for this machine, for use as a
+// passphrase when the user hasn't given one. It is not hidden from other users
+// of the machine, but a copied token file is useless anywhere else.
+func MachineSecret() (string, error) {
+	for _, file := range machineIdFiles {
+		if id, err := ioutil.ReadFile(file); err == nil && len(strings.TrimSpace(string(id))) > 0 {
+			return strings.TrimSpace(string(id)), nil
+		}
+	}
+
+	// Not as good, but better than nothing on systems without a machine id
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("failed to find a machine secret: %s", err)
+	}
+	return hostname, nil
+}
+
+// pbkdf2 derives a key from the passphrase as described in RFC 8018, using HMAC-SHA256
+func pbkdf2(passphrase string, salt []byte, iterations, length int) []byte {
+	prf := hmac.New(sha256.New, []byte(passphrase))
+	key := make([]byte, 0, length)
+	block := make([]byte, 4)
+	for i := uint32(1); len(key) < length; i++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(block, i)
+		prf.Write(block)
+		u := prf.Sum(nil)
+
+		t := make([]byte, len(u))
+		copy(t, u)
+		for n := 1; n < iterations; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:length]
+}
+
+func (s *EncryptedFileStore) cipher(salt []byte, iterations int) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(pbkdf2(s.passphrase, salt, iterations, keyLength))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (s *EncryptedFileStore) Load() (token TokenWithUsername, err error) {
+	fileData, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return token, ErrNoToken
+	} else if err != nil {
+		return
+	}
+
+	var encrypted encryptedToken
+	if err = json.Unmarshal(fileData, &encrypted); err != nil {
+		return token, fmt.Errorf("failed to parse %s: %s", s.Path, err)
+	}
+
+	aead, err := s.cipher(encrypted.Salt, encrypted.Iterations)
+	if err != nil {
+		return
+	}
+	if len(encrypted.Nonce) != aead.NonceSize() {
+		return token, fmt.Errorf("failed to parse %s: bad nonce", s.Path)
+	}
+
+	tokenData, err := aead.Open(nil, encrypted.Nonce, encrypted.Data, nil)
+	if err != nil {
+		return token, fmt.Errorf("failed to decrypt %s. Has the passphrase changed?", s.Path)
+	}
+	err = json.Unmarshal(tokenData, &token)
+	return
+}
+
+func (s *EncryptedFileStore) Save(token TokenWithUsername) (err error) {
+	encrypted := encryptedToken{
+		Iterations: keyIterations,
+		Salt:       make([]byte, 16),
+	}
+	if _, err = rand.Read(encrypted.Salt); err != nil {
+		return
+	}
+
+	aead, err := s.cipher(encrypted.Salt, encrypted.Iterations)
+	if err != nil {
+		return
+	}
+	encrypted.Nonce = make([]byte, aead.NonceSize())
+	if _, err = rand.Read(encrypted.Nonce); err != nil {
+		return
+	}
+
+	tokenData, _ := json.Marshal(token)
+	encrypted.Data = aead.Seal(nil, encrypted.Nonce, tokenData, nil)
+
+	fileData, _ := json.Marshal(encrypted)
+	return writeFileAtomic(s.Path, fileData)
+}
+
+func (s *EncryptedFileStore) Delete() error {
+	if err := os.Remove(s.Path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
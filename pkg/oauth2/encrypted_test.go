@@ -0,0 +1,56 @@
+package oauth2
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryptedFileStore(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "token.enc")
+
+	checkStore(t, NewEncryptedFileStore(path, "passphrase"))
+
+	if err := NewEncryptedFileStore(path, "passphrase").Save(testToken()); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"access", "refresh", "someone"} {
+		if bytes.Contains(data, []byte(secret)) {
+			t.Errorf("%s is not encrypted", secret)
+		}
+	}
+
+	if _, err = NewEncryptedFileStore(path, "wrong").Load(); err == nil || err == ErrNoToken {
+		t.Errorf("expected an error with the wrong passphrase, got %v", err)
+	}
+}
+
+func TestPBKDF2(t *testing.T) {
+	// PBKDF2-HMAC-SHA256 vectors from RFC 7914 section 11
+	for _, vector := range []struct {
+		passphrase string
+		salt       string
+		iterations int
+		key        string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" +
+			"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56" +
+			"a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+	} {
+		key := hex.EncodeToString(pbkdf2(vector.passphrase, []byte(vector.salt), vector.iterations, 64))
+		if key != vector.key {
+			t.Errorf("pbkdf2(%s, %s, %d) = %s, expected %s",
+				vector.passphrase, vector.salt, vector.iterations, key, vector.key)
+		}
+	}
+}
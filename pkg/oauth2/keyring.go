@@ -0,0 +1,87 @@
+package oauth2
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// ErrNotInKeyring is returned by Keyring.Get when there is no secret for the key
+var ErrNotInKeyring = errors.New("secret not found in keyring")
+
+// Keyring stores secrets by service and key, like the Secret Service on Linux desktops
+type Keyring interface {
+	Get(service, key string) (string, error)
+	Set(service, key, secret string) error
+	// Delete removes the secret. Deleting a missing secret is not an error
+	Delete(service, key string) error
+}
+
+// SecretTool uses the Secret Service through the secret-tool command from libsecret.
+// This works with GNOME Keyring, KWallet and KeePassXC among others.
+type SecretTool struct{}
+
+func (SecretTool) run(stdin string, args ...string) (string, error) {
+	cmd := exec.Command("secret-tool", args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); ok && stderr.Len() == 0 && args[0] == "lookup" {
+			// secret-tool exits with 1 and says nothing when the secret doesn't exist
+			return "", ErrNotInKeyring
+		}
+		return "", fmt.Errorf("secret-tool %s failed: %s. Output: %s", args[0], err, stderr)
+	}
+	return stdout.String(), nil
+}
+
+func (t SecretTool) Get(service, key string) (string, error) {
+	return t.run("", "lookup", "service", service, "key", key)
+}
+
+func (t SecretTool) Set(service, key, secret string) error {
+	_, err := t.run(secret, "store", "--label", service+" "+key, "service", service, "key", key)
+	return err
+}
+
+func (t SecretTool) Delete(service, key string) error {
+	// Clearing a missing secret succeeds
+	_, err := t.run("", "clear", "service", service, "key", key)
+	return err
+}
+
+// KeyringStore keeps the token as JSON in a Keyring
+type KeyringStore struct {
+	Keyring Keyring
+	Service string
+	Key     string
+}
+
+func NewKeyringStore(keyring Keyring, service, key string) *KeyringStore {
+	return &KeyringStore{Keyring: keyring, Service: service, Key: key}
+}
+
+func (s *KeyringStore) Load() (token TokenWithUsername, err error) {
+	secret, err := s.Keyring.Get(s.Service, s.Key)
+	if err == ErrNotInKeyring {
+		return token, ErrNoToken
+	} else if err != nil {
+		return
+	}
+	err = json.Unmarshal([]byte(secret), &token)
+	return
+}
+
+func (s *KeyringStore) Save(token TokenWithUsername) error {
+	jsonData, _ := json.Marshal(token)
+	return s.Keyring.Set(s.Service, s.Key, string(jsonData))
+}
+
+func (s *KeyringStore) Delete() error {
+	return s.Keyring.Delete(s.Service, s.Key)
+}
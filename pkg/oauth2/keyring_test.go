@@ -0,0 +1,54 @@
+package oauth2
+
+import (
+	"sync"
+	"testing"
+)
+
+// memoryKeyring keeps secrets in memory, in place of a real keyring
+type memoryKeyring struct {
+	mutex   sync.Mutex
+	secrets map[string]string
+}
+
+func newmemoryKeyring() *memoryKeyring {
+	return &memoryKeyring{secrets: map[string]string{}}
+}
+
+func (k *memoryKeyring) Get(service, key string) (string, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	secret, ok := k.secrets[service+"\x00"+key]
+	if !ok {
+		return "", ErrNotInKeyring
+	}
+	return secret, nil
+}
+
+func (k *memoryKeyring) Set(service, key, secret string) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.secrets[service+"\x00"+key] = secret
+	return nil
+}
+
+func (k *memoryKeyring) Delete(service, key string) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	delete(k.secrets, service+"\x00"+key)
+	return nil
+}
+
+func TestKeyringStore(t *testing.T) {
+	keyring := newmemoryKeyring()
+	checkStore(t, NewKeyringStore(keyring, "bgur", "token"))
+
+	// Other keys are left alone
+	if err := keyring.Set("bgur", "other", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	checkStore(t, NewKeyringStore(keyring, "bgur", "token"))
+	if secret, err := keyring.Get("bgur", "other"); err != nil || secret != "secret" {
+		t.Errorf("other key was changed: %q %v", secret, err)
+	}
+}
@@ -0,0 +1,95 @@
+package oauth2
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+// RejectedError is returned when the provider refuses to refresh the token,
+// usually because access was revoked. The only fix is to authorise again.
+type RejectedError struct {
+	Err *oauth2.RetrieveError
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("the saved token was rejected, so it has been forgotten. Authorise again. %s", e.Err)
+}
+
+// isRejected tells whether the provider refused the token itself. Other errors,
+// such as rate limiting, are temporary. Errors are recognised as described in
+// RFC 6749 section 5.2, or in Imgur's envelope:
+//
+//	{"data":{"error":"Invalid refresh token"},"success":false,"status":400}
+func isRejected(err *oauth2.RetrieveError) bool {
+	if err.Response == nil {
+		return false
+	}
+	if err.Response.StatusCode != http.StatusBadRequest && err.Response.StatusCode != http.StatusUnauthorized {
+		return false
+	}
+
+	var body struct {
+		Error string          `json:"error"`
+		Data  json.RawMessage `json:"data"`
+	}
+	if json.Unmarshal(err.Body, &body) != nil {
+		return false
+	}
+	if body.Error == "invalid_grant" || body.Error == "invalid_client" {
+		return true
+	}
+
+	// data is sometimes a plain string, which isn't about the token
+	var data struct {
+		Error string `json:"error"`
+	}
+	return json.Unmarshal(body.Data, &data) == nil && data.Error != ""
+}
+
+// persistingSource saves every new token it gets from source. Refreshing can
+// replace the refresh token, which would be lost if it was only kept in memory.
+type persistingSource struct {
+	api    *API
+	source oauth2.TokenSource
+	mutex  sync.Mutex
+	// Access token which was saved last
+	saved string
+}
+
+func (s *persistingSource) Token() (*oauth2.Token, error) {
+	token, saveErr, err := s.refresh()
+	if saveErr != nil {
+		// The token still works until bgur exits
+		fmt.Println("Failed to save the refreshed token: ", saveErr)
+	}
+	return token, err
+}
+
+// refresh gets a token from source and saves it if it is new. saveErr is set
+// when the token is fine but couldn't be saved.
+func (s *persistingSource) refresh() (token *oauth2.Token, saveErr, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	token, err = s.source.Token()
+	if retrieveErr, ok := err.(*oauth2.RetrieveError); ok && isRejected(retrieveErr) {
+		// Don't try the same token again next time
+		if deleteErr := s.api.Store.Delete(); deleteErr != nil {
+			fmt.Println("Failed to forget the rejected token: ", deleteErr)
+		}
+		return nil, nil, &RejectedError{retrieveErr}
+	} else if err != nil {
+		return
+	}
+
+	if token.AccessToken != s.saved {
+		if saveErr = s.api.saveToken(token); saveErr == nil {
+			s.saved = token.AccessToken
+		}
+	}
+	return
+}
@@ -0,0 +1,33 @@
+package oauth2
+
+import (
+	"net/http"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestIsRejected(t *testing.T) {
+	for _, test := range []struct {
+		status   int
+		body     string
+		rejected bool
+	}{
+		{http.StatusBadRequest, `{"error":"invalid_grant","error_description":"expired"}`, true},
+		{http.StatusUnauthorized, `{"error":"invalid_client"}`, true},
+		{http.StatusBadRequest, `{"error":"invalid_request"}`, false},
+		{http.StatusBadRequest, `{"data":{"error":"Invalid refresh token","request":"/oauth2/token","method":"POST"},"success":false,"status":400}`, true},
+		{http.StatusUnauthorized, `{"data":{"error":"Invalid client_id"},"success":false,"status":401}`, true},
+		{http.StatusBadRequest, `{"data":"Bad request","success":false,"status":400}`, false},
+		{http.StatusTooManyRequests, `{"data":{"error":"Too Many Requests"},"success":false,"status":429}`, false},
+		{http.StatusTooManyRequests, `{"error":"invalid_grant"}`, false},
+		{http.StatusForbidden, `{"error":"invalid_grant"}`, false},
+		{http.StatusInternalServerError, `{"data":{"error":"Internal error"},"success":false,"status":500}`, false},
+		{http.StatusBadRequest, `<html>Bad Request</html>`, false},
+	} {
+		err := &oauth2.RetrieveError{Response: &http.Response{StatusCode: test.status}, Body: []byte(test.body)}
+		if rejected := isRejected(err); rejected != test.rejected {
+			t.Errorf("isRejected(%d %s) = %t, expected %t", test.status, test.body, rejected, test.rejected)
+		}
+	}
+}
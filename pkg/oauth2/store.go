@@ -0,0 +1,96 @@
+package oauth2
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// ErrNoToken is returned by TokenStore.Load when nothing has been saved yet
+var ErrNoToken = errors.New("no token saved")
+
+// TokenStore keeps the token between runs
+type TokenStore interface {
+	Load() (TokenWithUsername, error)
+	Save(token TokenWithUsername) error
+	// Delete removes the token. Deleting a missing token is not an error
+	Delete() error
+}
+
+// FileStore keeps the token in a plain JSON file
+type FileStore struct {
+	Path string
+}
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{Path: path}
+}
+
+func (s *FileStore) Load() (token TokenWithUsername, err error) {
+	tokenData, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return token, ErrNoToken
+	} else if err != nil {
+		return
+	}
+	err = json.Unmarshal(tokenData, &token)
+	return
+}
+
+func (s *FileStore) Save(token TokenWithUsername) error {
+	jsonData, _ := json.Marshal(token)
+	return writeFileAtomic(s.Path, jsonData)
+}
+
+func (s *FileStore) Delete() error {
+	if err := os.Remove(s.Path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Migrate moves the token from one store to another, unless the other store already has one
+func Migrate(from, to TokenStore) error {
+	token, err := from.Load()
+	if err == ErrNoToken {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read the old token: %s", err)
+	}
+
+	if _, err = to.Load(); err == ErrNoToken {
+		if err = to.Save(token); err != nil {
+			return fmt.Errorf("failed to move the token: %s", err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to read the new token: %s", err)
+	}
+	return from.Delete()
+}
+
+// writeFileAtomic writes to a temporary file first so that a partially written
+// token never replaces a good one. Only the current user can read the file.
+func writeFileAtomic(filePath string, data []byte) error {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(filePath), "."+filepath.Base(filePath)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmpFile.Write(data)
+	if err2 := tmpFile.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Chmod(tmpFile.Name(), 0600)
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), filePath)
+	}
+	if err != nil {
+		_ = os.Remove(tmpFile.Name())
+	}
+	return err
+}
@@ -0,0 +1,94 @@
+package oauth2
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func testToken() TokenWithUsername {
+	return TokenWithUsername{&oauth2.Token{AccessToken: "access", RefreshToken: "refresh"}, "someone"}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "bgur-oauth2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// checkStore saves, loads and deletes a token
+func checkStore(t *testing.T, store TokenStore) {
+	if _, err := store.Load(); err != ErrNoToken {
+		t.Fatalf("expected ErrNoToken from an empty store, got %v", err)
+	}
+
+	if err := store.Save(testToken()); err != nil {
+		t.Fatal(err)
+	}
+	token, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Token.AccessToken != "access" || token.Token.RefreshToken != "refresh" || token.Username != "someone" {
+		t.Errorf("loaded a different token: %+v %+v", token, token.Token)
+	}
+
+	if err = store.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Load(); err != ErrNoToken {
+		t.Errorf("expected ErrNoToken after deleting, got %v", err)
+	}
+	if err = store.Delete(); err != nil {
+		t.Errorf("deleting a missing token failed: %s", err)
+	}
+}
+
+// failingStore fails to save, like a locked keyring
+type failingStore struct {
+	TokenStore
+}
+
+func (failingStore) Save(TokenWithUsername) error {
+	return errors.New("keyring is locked")
+}
+
+func TestMigrate(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	plain := NewFileStore(filepath.Join(dir, "token.json"))
+
+	// Nothing to move
+	store := NewKeyringStore(newmemoryKeyring(), "bgur", "token")
+	if err := Migrate(plain, store); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := plain.Save(testToken()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The plain token is kept when it can't be moved
+	if err := Migrate(plain, failingStore{store}); err == nil {
+		t.Error("expected an error when the token can't be saved")
+	}
+	if _, err := os.Stat(plain.Path); err != nil {
+		t.Errorf("token.json was removed after a failed move: %s", err)
+	}
+
+	if err := Migrate(plain, store); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(plain.Path); !os.IsNotExist(err) {
+		t.Errorf("token.json was not removed after moving it: %v", err)
+	}
+	if token, err := store.Load(); err != nil || token.Token.RefreshToken != "refresh" {
+		t.Errorf("token was not moved: %+v %v", token, err)
+	}
+}